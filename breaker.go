@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"goFactServView/cwlog"
+	"math/rand/v2"
+	"net"
+	"net/url"
+	"time"
+)
+
+// Circuit breaker states
+const (
+	BREAKER_CLOSED = iota
+	BREAKER_OPEN
+	BREAKER_HALFOPEN
+)
+
+// Upstream error classes
+const (
+	ERR_NETWORK = iota
+	ERR_RATELIMIT
+	ERR_SERVER
+	ERR_AUTH
+	ERR_DATA
+)
+
+const (
+	//Failures in a row before the circuit opens
+	BreakerThreshold = 3
+	//Random +/- fraction added to each backoff
+	BackoffJitter = 0.2
+)
+
+// Backoff for each error class: first delay, and the most we'll wait
+var backoffLimits = map[int]struct{ Base, Max time.Duration }{
+	ERR_NETWORK:   {Base: time.Minute, Max: time.Minute * 15},
+	ERR_RATELIMIT: {Base: time.Minute * 5, Max: time.Hour},
+	ERR_SERVER:    {Base: time.Minute * 2, Max: time.Minute * 30},
+	ERR_AUTH:      {Base: time.Minute * 15, Max: time.Hour * 3},
+	ERR_DATA:      {Base: time.Minute * 2, Max: time.Minute * 30},
+}
+
+var errClassNames = map[int]string{
+	ERR_NETWORK:   "network",
+	ERR_RATELIMIT: "rate limited",
+	ERR_SERVER:    "server error",
+	ERR_AUTH:      "auth",
+	ERR_DATA:      "bad data",
+}
+
+// Non-200 from upstream
+type upstreamStatusError struct {
+	Code int
+	Body string
+}
+
+func (err *upstreamStatusError) Error() string {
+	return fmt.Sprintf("unexpected upstream status %d: %s", err.Code, err.Body)
+}
+
+// Tracks upstream health, and when we may try it again
+type circuitBreaker struct {
+	State     int
+	Failures  int
+	Opens     int
+	LastClass int
+	LastError string
+	OpenUntil time.Time
+}
+
+// Sort an error into a backoff class
+func classifyError(err error) int {
+	var statusErr *upstreamStatusError
+	if errors.As(err, &statusErr) {
+		switch {
+		case statusErr.Code == 429:
+			return ERR_RATELIMIT
+		case statusErr.Code == 401 || statusErr.Code == 403:
+			return ERR_AUTH
+		case statusErr.Code >= 500:
+			return ERR_SERVER
+		}
+		return ERR_DATA
+	}
+
+	var netErr net.Error
+	var urlErr *url.Error
+	if errors.As(err, &netErr) || errors.As(err, &urlErr) {
+		return ERR_NETWORK
+	}
+	return ERR_DATA
+}
+
+// Exponential backoff with jitter, for the nth time the circuit opened
+func backoffDelay(class, attempt int) time.Duration {
+	limits := backoffLimits[class]
+	delay := limits.Base
+	for i := 1; i < attempt && delay < limits.Max; i++ {
+		delay *= 2
+	}
+	if delay > limits.Max {
+		delay = limits.Max
+	}
+
+	jitter := 1 + BackoffJitter*(rand.Float64()*2-1)
+	return time.Duration(float64(delay) * jitter)
+}
+
+// May we try upstream now? Moves open to half-open once the backoff is up.
+func (cb *circuitBreaker) allow() bool {
+	if cb.State != BREAKER_OPEN {
+		return true
+	}
+	if time.Now().Before(cb.OpenUntil) {
+		return false
+	}
+	cb.State = BREAKER_HALFOPEN
+	cwlog.DoLog(true, "Circuit half-open, trying upstream again.")
+	return true
+}
+
+func (cb *circuitBreaker) success(name string) {
+	if cb.State != BREAKER_CLOSED || cb.Failures > 0 {
+		cwlog.DoLog(true, "%v recovered after %v failures, circuit closed.", name, cb.Failures)
+	}
+	*cb = circuitBreaker{}
+}
+
+func (cb *circuitBreaker) failure(name string, err error) {
+	cb.Failures++
+	cb.LastClass = classifyError(err)
+
+	//Only log repeats of the same error when the state changes
+	repeat := cb.LastError == err.Error()
+	cb.LastError = err.Error()
+
+	if cb.State == BREAKER_CLOSED && cb.Failures < BreakerThreshold {
+		if !repeat {
+			cwlog.DoLog(true, "fetchServerList: %v: %v", name, err)
+		}
+		return
+	}
+
+	cb.Opens++
+	delay := backoffDelay(cb.LastClass, cb.Opens)
+	cb.OpenUntil = time.Now().Add(delay)
+
+	if cb.State == BREAKER_HALFOPEN {
+		cwlog.DoLog(true, "fetchServerList: %v still failing (%v): %v, circuit open for %v",
+			name, errClassNames[cb.LastClass], err, delay.Round(time.Second))
+	} else {
+		cwlog.DoLog(true, "fetchServerList: %v failed %v times (%v): %v, circuit open for %v",
+			name, cb.Failures, errClassNames[cb.LastClass], err, delay.Round(time.Second))
+	}
+	cb.State = BREAKER_OPEN
+}
+
+// Returned when we skip upstream because the circuit is open
+func (cb circuitBreaker) openErr() error {
+	return fmt.Errorf("circuit open after %v failures (%v), next attempt in %v",
+		cb.Failures, errClassNames[cb.LastClass], cb.RetryIn())
+}
+
+// Upstream isn't healthy, for web
+func (cb circuitBreaker) Tripped() bool {
+	return cb.State != BREAKER_CLOSED
+}
+
+func (cb circuitBreaker) StateName() string {
+	switch cb.State {
+	case BREAKER_OPEN:
+		return "open"
+	case BREAKER_HALFOPEN:
+		return "half-open"
+	}
+	return "closed"
+}
+
+func (cb circuitBreaker) ClassName() string {
+	return errClassNames[cb.LastClass]
+}
+
+// Time until the next attempt, for web
+func (cb circuitBreaker) RetryIn() string {
+	wait := time.Until(cb.OpenUntil)
+	if wait <= 0 {
+		return "now"
+	}
+	return updateTime(int(wait.Minutes()) + 1)
+}
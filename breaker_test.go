@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestClassifyError(t *testing.T) {
+	cases := []struct {
+		err  error
+		want int
+	}{
+		{&upstreamStatusError{Code: 429}, ERR_RATELIMIT},
+		{&upstreamStatusError{Code: 403}, ERR_AUTH},
+		{&upstreamStatusError{Code: 502}, ERR_SERVER},
+		{&upstreamStatusError{Code: 404}, ERR_DATA},
+		{fmt.Errorf("request failed: %w", &url.Error{Op: "Get", URL: "x", Err: errors.New("refused")}), ERR_NETWORK},
+		{errors.New("invalid JSON"), ERR_DATA},
+	}
+	for _, c := range cases {
+		if got := classifyError(c.err); got != c.want {
+			t.Fatalf("classifyError(%v) = %d, want %d", c.err, got, c.want)
+		}
+	}
+}
+
+func TestBackoffDelayGrowsWithinLimits(t *testing.T) {
+	limits := backoffLimits[ERR_SERVER]
+	for attempt := 1; attempt <= 10; attempt++ {
+		delay := backoffDelay(ERR_SERVER, attempt)
+		want := limits.Base << (attempt - 1)
+		if want > limits.Max {
+			want = limits.Max
+		}
+		low := time.Duration(float64(want) * (1 - BackoffJitter))
+		high := time.Duration(float64(want) * (1 + BackoffJitter))
+		if delay < low || delay > high {
+			t.Fatalf("attempt %d: delay %v outside %v-%v", attempt, delay, low, high)
+		}
+	}
+}
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	setupDurafmt()
+
+	cb := circuitBreaker{}
+	err := &upstreamStatusError{Code: 503}
+
+	for i := 0; i < BreakerThreshold; i++ {
+		if !cb.allow() {
+			t.Fatalf("expected closed circuit to allow attempt %d", i)
+		}
+		cb.failure("test", err)
+	}
+	if cb.State != BREAKER_OPEN || cb.allow() {
+		t.Fatalf("expected open circuit after %d failures, got %v", BreakerThreshold, cb.StateName())
+	}
+
+	//Backoff is up, one trial allowed
+	cb.OpenUntil = time.Now().Add(-time.Second)
+	if !cb.allow() || cb.State != BREAKER_HALFOPEN {
+		t.Fatalf("expected half-open circuit, got %v", cb.StateName())
+	}
+	cb.failure("test", err)
+	if cb.State != BREAKER_OPEN || cb.Opens != 2 {
+		t.Fatalf("expected circuit to reopen, got %v after %d opens", cb.StateName(), cb.Opens)
+	}
+
+	cb.OpenUntil = time.Now().Add(-time.Second)
+	cb.allow()
+	cb.success("test")
+	if cb.State != BREAKER_CLOSED || cb.Failures != 0 || cb.Opens != 0 {
+		t.Fatalf("expected reset after success, got %+v", cb)
+	}
+}
+
+func TestFetchServerListSkipsUpstreamWhileOpen(t *testing.T) {
+	setupDurafmt()
+
+	restore := configureFetchTestState(t)
+	defer restore()
+
+	calls := 0
+	serverSource = &countingSource{stubSource: stubSource{err: errors.New("boom")}, calls: &calls}
+
+	for i := 0; i < BreakerThreshold+2; i++ {
+		lastAttempt.Store(0)
+		if err := fetchServerList(); err == nil {
+			t.Fatal("expected error")
+		}
+	}
+	if calls != BreakerThreshold {
+		t.Fatalf("expected %d upstream calls before the circuit opened, got %d", BreakerThreshold, calls)
+	}
+}
+
+type countingSource struct {
+	stubSource
+	calls *int
+}
+
+func (src *countingSource) Fetch() ([]ServerListItem, error) {
+	*src.calls++
+	return src.stubSource.Fetch()
+}
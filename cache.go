@@ -5,104 +5,121 @@ import (
 	"encoding/json"
 	"goFactServView/cwlog"
 	"os"
+	"path/filepath"
 	"time"
 )
 
 const (
-	CacheFile    = "data/cache.json"
-	CacheVersion = 2
+	//Bumping this needs a migration in migrate.go and a testdata fixture
+	CacheVersion = 4
+	//Older good caches kept next to CacheFile, as cache.json.1 and up
+	CacheBackups = 3
 )
 
-func ReadServerCache() {
-
-	FetchLock.Lock()
-	defer FetchLock.Unlock()
-
-	_, err := os.Stat(CacheFile)
-	notfound := os.IsNotExist(err)
+var CacheFile = "data/cache.json"
 
-	if notfound {
-		return
-	} else { /* Otherwise just read in the config */
-		info, err := os.Stat(CacheFile)
-		lastRefresh := time.Time{}
-		if err == nil {
-			lastRefresh = info.ModTime()
+// Load the newest cache that verifies, primary first, then backups
+func ReadServerCache() {
+	for _, path := range cachePaths() {
+		cache, modTime, err := readCacheFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			cwlog.DoLog(true, "ReadServerCache: %v: %v", path, err)
+			continue
+		}
+		if len(cache.Servers) <= MinValidCount {
+			cwlog.DoLog(true, "ReadServerCache: %v: only %v servers", path, len(cache.Servers))
+			continue
 		}
 
-		file, err := os.ReadFile(CacheFile)
-
-		if file != nil && err == nil {
-
-			tempServerList := CacheData{}
-			err := json.Unmarshal([]byte(file), &tempServerList)
-			if err != nil {
-				cwlog.DoLog(true, "ReadServerList: Unmarshal failure")
-				return
-			}
-			if tempServerList.Version < CacheVersion {
-				cwlog.DoLog(false, "Cache data is incompatable, skipping.")
-				return
-			}
-
-			if len(tempServerList.Servers) > MinValidCount {
-				sParam.ServerList.Servers = sortServers(false, tempServerList.Servers, SORT_PLAYER)
-				getVersions()
-				sParam.LastRefresh = lastRefresh
-				sParam.ServersCount = len(tempServerList.Servers)
-
-				cwlog.DoLog(true, "Read cached server list.")
-			}
+		snap := newSnapshot(sortServers(false, cache.Servers, SORT_PLAYER), modTime)
+		snap.Mirrored = cache.Mirrored
+		snap.ETag = cache.ETag
+		snap.LastModified = cache.LastModified
+		publishSnapshot(snap)
 
-			totalPlayers := 0
-			for _, item := range tempServerList.Servers {
-				totalPlayers = totalPlayers + len(item.Players)
-			}
-			sParam.PlayerCount = totalPlayers
-			return
-		} else {
-			cwlog.DoLog(true, "ReadServerList: Read file failure")
-			return
-		}
+		cwlog.DoLog(true, "Read cached server list from %v.", path)
+		return
 	}
+}
 
+// Read, verify and upgrade one cache file
+func readCacheFile(path string) (CacheData, time.Time, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return CacheData{}, time.Time{}, err
+	}
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return CacheData{}, time.Time{}, err
+	}
+	if err := verifyCacheChecksum(body); err != nil {
+		return CacheData{}, time.Time{}, err
+	}
+	cache, err := migrateCache(body)
+	if err != nil {
+		return CacheData{}, time.Time{}, err
+	}
+	return cache, info.ModTime(), nil
 }
 
-func WriteServerCache() {
+// Save a snapshot durably, keeping the last good caches as backups
+func WriteServerCache(snap *Snapshot) {
+	if len(snap.Servers) <= MinValidCount {
+		return
+	}
 
-	tempPath := CacheFile + ".tmp"
+	cache := snapshotCacheData(snap)
+	servers, err := json.Marshal(cache.Servers)
+	if err != nil {
+		cwlog.DoLog(true, "WriteServerCache: %v", err)
+		return
+	}
+	cache.Checksum, err = cacheChecksum(servers)
+	if err != nil {
+		cwlog.DoLog(true, "WriteServerCache: %v", err)
+		return
+	}
 
 	outbuf := new(bytes.Buffer)
 	enc := json.NewEncoder(outbuf)
 	enc.SetIndent("", "\t")
-
-	if len(sParam.ServerList.Servers) <= MinValidCount {
+	if err := enc.Encode(cache); err != nil {
+		cwlog.DoLog(true, "WriteServerCache: %v", err)
 		return
 	}
 
-	sParam.ServerList.Version = CacheVersion
-	if err := enc.Encode(sParam.ServerList); err != nil {
-		cwlog.DoLog(true, "WriteServerList: enc.Encode failure")
+	tempPath := CacheFile + ".tmp"
+	if err := writeFileSynced(tempPath, outbuf.Bytes()); err != nil {
+		cwlog.DoLog(true, "WriteServerCache: %v", err)
 		return
 	}
-
-	_, err := os.Create(tempPath)
-
-	if err != nil {
-		cwlog.DoLog(true, "WriteServerList: os.Create failure")
+	rotateCacheBackups()
+	if err := os.Rename(tempPath, CacheFile); err != nil {
+		cwlog.DoLog(true, "WriteServerCache: %v", err)
 		return
 	}
-
-	err = os.WriteFile(tempPath, outbuf.Bytes(), 0644)
-
-	if err != nil {
-		cwlog.DoLog(true, "WriteServerList: Write file failure")
+	if err := syncDir(filepath.Dir(CacheFile)); err != nil {
+		cwlog.DoLog(true, "WriteServerCache: %v", err)
 	}
+}
 
-	err = os.Rename(tempPath, CacheFile)
+// Mark the cache as fresh without rewriting it
+func touchServerCache(when time.Time) {
+	if err := os.Chtimes(CacheFile, when, when); err != nil && !os.IsNotExist(err) {
+		cwlog.DoLog(true, "touchServerCache: %v", err)
+	}
+}
 
-	if err != nil {
-		cwlog.DoLog(true, "Couldn't rename cache file.")
-		return
+// What we write to the cache file, and serve to peers
+func snapshotCacheData(snap *Snapshot) CacheData {
+	return CacheData{
+		Version:      CacheVersion,
+		Servers:      snap.Servers,
+		Mirrored:     snap.Mirrored,
+		ETag:         snap.ETag,
+		LastModified: snap.LastModified,
 	}
 }
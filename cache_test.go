@@ -0,0 +1,104 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func configureCacheTestState(t *testing.T) func() {
+	t.Helper()
+
+	oldFile := CacheFile
+	CacheFile = filepath.Join(t.TempDir(), "cache.json")
+	oldSnap := currentSnapshot.Load()
+	publishSnapshot(&Snapshot{})
+
+	return func() {
+		CacheFile = oldFile
+		currentSnapshot.Store(oldSnap)
+	}
+}
+
+func TestWriteServerCacheRoundTrip(t *testing.T) {
+	setupDurafmt()
+	restore := configureCacheTestState(t)
+	defer restore()
+
+	WriteServerCache(newSnapshot(processServerList(seedServers(MinValidCount+1)), time.Now()))
+
+	body, err := os.ReadFile(CacheFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), `"Checksum": "sha256:`) {
+		t.Fatal("expected checksum in cache file")
+	}
+	if _, err := os.Stat(CacheFile + ".tmp"); !os.IsNotExist(err) {
+		t.Fatal("expected temp file to be gone")
+	}
+
+	ReadServerCache()
+	if getSnapshot().ServersCount != MinValidCount+1 {
+		t.Fatalf("expected %d servers, got %d", MinValidCount+1, getSnapshot().ServersCount)
+	}
+}
+
+func TestWriteServerCacheRotatesBackups(t *testing.T) {
+	setupDurafmt()
+	restore := configureCacheTestState(t)
+	defer restore()
+
+	for i := 0; i < CacheBackups+2; i++ {
+		WriteServerCache(newSnapshot(processServerList(seedServers(MinValidCount+1+i)), time.Now()))
+	}
+
+	for i := 1; i <= CacheBackups; i++ {
+		cache, _, err := readCacheFile(cacheBackupPath(i))
+		if err != nil {
+			t.Fatalf("backup %d: %v", i, err)
+		}
+		//Newest backup is the write before the last
+		if want := MinValidCount + 1 + CacheBackups + 1 - i; len(cache.Servers) != want {
+			t.Fatalf("backup %d: expected %d servers, got %d", i, want, len(cache.Servers))
+		}
+	}
+	if _, err := os.Stat(cacheBackupPath(CacheBackups + 1)); !os.IsNotExist(err) {
+		t.Fatal("expected no more than CacheBackups backups")
+	}
+}
+
+func TestReadServerCacheFallsBackToBackup(t *testing.T) {
+	setupDurafmt()
+	restore := configureCacheTestState(t)
+	defer restore()
+
+	WriteServerCache(newSnapshot(processServerList(seedServers(MinValidCount+1)), time.Now()))
+	WriteServerCache(newSnapshot(processServerList(seedServers(MinValidCount+2)), time.Now()))
+
+	//Flip a server name, the JSON is still valid but the checksum isn't
+	body, err := os.ReadFile(CacheFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := strings.Replace(string(body), "seed-1", "seed-X", 1)
+	if err := verifyCacheChecksum([]byte(corrupt)); err == nil || !strings.Contains(err.Error(), "mismatch") {
+		t.Fatalf("expected checksum mismatch, got %v", err)
+	}
+	writeTestFile(t, CacheFile, corrupt)
+
+	ReadServerCache()
+	if getSnapshot().ServersCount != MinValidCount+1 {
+		t.Fatalf("expected backup with %d servers, got %d", MinValidCount+1, getSnapshot().ServersCount)
+	}
+
+	//A truncated primary isn't kept as a backup
+	writeTestFile(t, CacheFile, corrupt[:len(corrupt)/2])
+	WriteServerCache(newSnapshot(processServerList(seedServers(MinValidCount+3)), time.Now()))
+	cache, _, err := readCacheFile(cacheBackupPath(1))
+	if err != nil || len(cache.Servers) != MinValidCount+1 {
+		t.Fatalf("expected backup 1 unchanged, got %d servers, %v", len(cache.Servers), err)
+	}
+}
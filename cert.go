@@ -1,15 +1,27 @@
 package main
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"fmt"
 	"goFactServView/cwlog"
+	"math/big"
+	"net"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 )
 
 var (
+	//Holds fullchain.pem and privkey.pem
+	certDir = "data/certs"
+
 	certLock    sync.RWMutex
 	currentCert *tls.Certificate
 )
@@ -38,9 +50,9 @@ func autoUpdateCert() {
 }
 
 func reloadCerts() error {
-	cert, err := tls.LoadX509KeyPair("data/certs/fullchain.pem", "data/certs/privkey.pem")
+	cert, err := tls.LoadX509KeyPair(certPath("fullchain.pem"), certPath("privkey.pem"))
 	if err != nil {
-		return fmt.Errorf("error loading TLS key pair data/certs/(fullchain.pem, privkey.pem): %w", err)
+		return fmt.Errorf("error loading TLS key pair %v/(fullchain.pem, privkey.pem): %w", certDir, err)
 	}
 
 	certLock.Lock()
@@ -69,12 +81,12 @@ func getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
 }
 
 func certFilesStat() (*os.FileInfo, *os.FileInfo) {
-	fullchainStat, err := os.Stat("data/certs/fullchain.pem")
+	fullchainStat, err := os.Stat(certPath("fullchain.pem"))
 	if err != nil {
 		return nil, nil
 	}
 
-	privkeyStat, err := os.Stat("data/certs/privkey.pem")
+	privkeyStat, err := os.Stat(certPath("privkey.pem"))
 	if err != nil {
 		return nil, nil
 	}
@@ -89,3 +101,68 @@ func certStatChanged(previous, current *os.FileInfo) bool {
 
 	return (*previous).Size() != (*current).Size() || (*previous).ModTime() != (*current).ModTime()
 }
+
+func certPath(name string) string {
+	return filepath.Join(certDir, name)
+}
+
+// Write a self-signed ECDSA certificate where reloadCerts looks for it
+func generateSelfSignedCert(hosts []string, validFor time.Duration) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hosts[0], Organization: []string{ProgName}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(validFor),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("creating certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(certDir, 0755); err != nil {
+		return err
+	}
+	//Key first, so the watcher never pairs a new cert with an old key for long
+	if err := writePEM(certPath("privkey.pem"), "PRIVATE KEY", keyDER, 0600); err != nil {
+		return err
+	}
+	return writePEM(certPath("fullchain.pem"), "CERTIFICATE", der, 0644)
+}
+
+func writePEM(path, blockType string, der []byte, perm os.FileMode) error {
+	body := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, body, perm); err != nil {
+		return err
+	}
+	//WriteFile keeps the mode of an existing file
+	if err := os.Chmod(tmp, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A subcommand, all of them share the flags in registerFlags
+type command struct {
+	Name  string
+	Usage string
+	//Extra flags for this command only
+	Flags func(fs *flag.FlagSet)
+	Run   func(fs *flag.FlagSet, opts *cliOptions) error
+}
+
+var (
+	exportFormat *string
+	exportOut    *string
+
+	certHosts *string
+	certDays  *int
+	certForce *bool
+)
+
+var commands = []command{
+	{Name: "serve", Usage: "run the web server (default)", Run: runServe},
+	{Name: "fetch-once", Usage: "fetch, validate and write the cache, then exit", Run: runFetchOnce},
+	{Name: "inspect-cache", Usage: "print counts, versions, age and checksum status of the cache and its backups", Run: runInspectCache},
+	{Name: "export", Usage: "write the cached server list as csv, ndjson or json", Run: runExport,
+		Flags: func(fs *flag.FlagSet) {
+			exportFormat = fs.String("format", "json", "export format: csv, ndjson or json")
+			exportOut = fs.String("out", "-", "file to write, - for stdout")
+		}},
+	{Name: "gen-cert", Usage: "write a self-signed certificate to -certDir", Run: runGenCert,
+		Flags: func(fs *flag.FlagSet) {
+			certHosts = fs.String("hosts", "localhost", "comma-separated host names and IPs for the certificate")
+			certDays = fs.Int("days", 365, "days the certificate is valid for")
+			certForce = fs.Bool("force", false, "replace an existing certificate")
+		}},
+}
+
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].Name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+func printCommands() {
+	fmt.Fprintf(os.Stderr, "Usage: %v [command] [flags]\n\nCommands:\n", ProgName)
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-14v %v\n", cmd.Name, cmd.Usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nUse %v <command> -h for its flags.\n", ProgName)
+}
+
+// Fetch once, stale or not, and write the cache
+func runFetchOnce(fs *flag.FlagSet, opts *cliOptions) error {
+	if offline {
+		return errors.New("can't fetch in offline mode")
+	}
+	if err := setupSource(opts); err != nil {
+		return err
+	}
+	setupDurafmt()
+
+	//Previous list, for drop checks and conditional requests
+	ReadServerCache()
+	if len(historyRetention) > 0 {
+		if err := loadHistoryIndex(); err != nil {
+			return err
+		}
+	}
+
+	RefreshLock.Lock()
+	defer RefreshLock.Unlock()
+	if err := refreshServerList(); err != nil {
+		return err
+	}
+
+	snap := getSnapshot()
+	fmt.Printf("Fetched %v servers, %v players, from %v.\n", snap.ServersCount, snap.PlayerCount, serverSource.Name())
+	return nil
+}
+
+// Print what's in the cache and each backup
+func runInspectCache(fs *flag.FlagSet, opts *cliOptions) error {
+	setupDurafmt()
+
+	found := false
+	for _, path := range cachePaths() {
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		found = true
+		inspectCacheFile(os.Stdout, path, info)
+	}
+	if !found {
+		return fmt.Errorf("no cache at %v", CacheFile)
+	}
+	return nil
+}
+
+func inspectCacheFile(w io.Writer, path string, info os.FileInfo) {
+	fmt.Fprintf(w, "%v\n", path)
+	fmt.Fprintf(w, "  Size:      %v bytes\n", info.Size())
+	fmt.Fprintf(w, "  Written:   %v (%v ago)\n", info.ModTime().UTC().Format(time.RFC1123),
+		updateTime(int(time.Since(info.ModTime()).Minutes())))
+
+	body, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(w, "  Error:     %v\n\n", err)
+		return
+	}
+
+	var header struct {
+		Version  int
+		Checksum string
+	}
+	json.Unmarshal(body, &header)
+	fmt.Fprintf(w, "  Version:   %v\n", header.Version)
+
+	if err := verifyCacheChecksum(body); err != nil {
+		fmt.Fprintf(w, "  Checksum:  BAD, %v\n\n", err)
+		return
+	} else if header.Checksum == "" {
+		fmt.Fprintf(w, "  Checksum:  none, written before checksums\n")
+	} else {
+		fmt.Fprintf(w, "  Checksum:  ok\n")
+	}
+
+	cache, err := migrateCache(body)
+	if err != nil {
+		fmt.Fprintf(w, "  Error:     %v\n\n", err)
+		return
+	}
+	snap := newSnapshot(cache.Servers, info.ModTime())
+	fmt.Fprintf(w, "  Servers:   %v\n", snap.ServersCount)
+	fmt.Fprintf(w, "  Players:   %v\n", snap.PlayerCount)
+	if cache.Mirrored {
+		fmt.Fprintf(w, "  Mirrored:  yes\n")
+	}
+	fmt.Fprintf(w, "  Versions:\n")
+	for i, ver := range snap.VersionList {
+		if i == 10 {
+			fmt.Fprintf(w, "    ... %v more\n", len(snap.VersionList)-i)
+			break
+		}
+		fmt.Fprintf(w, "    %-10v %v\n", ver.Version, ver.Count)
+	}
+	fmt.Fprintln(w)
+}
+
+// Write the cached list in another format
+func runExport(fs *flag.FlagSet, opts *cliOptions) error {
+	ReadServerCache()
+	snap := getSnapshot()
+	if snap.ServersCount == 0 {
+		return fmt.Errorf("no usable cache at %v", CacheFile)
+	}
+
+	var out io.Writer = os.Stdout
+	if *exportOut != "-" {
+		file, err := os.Create(*exportOut)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+
+	switch strings.ToLower(*exportFormat) {
+	case "json":
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "\t")
+		return enc.Encode(snapshotCacheData(snap))
+	case "ndjson":
+		enc := json.NewEncoder(out)
+		for _, item := range snap.Servers {
+			if err := enc.Encode(item); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "csv":
+		return exportCSV(out, snap.Servers)
+	default:
+		return fmt.Errorf("unknown format %q, use csv, ndjson or json", *exportFormat)
+	}
+}
+
+func exportCSV(w io.Writer, servers []ServerListItem) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"game_id", "name", "description", "host_address", "game_version",
+		"players", "player_names", "mod_count", "has_password", "minutes", "tags"})
+	for _, item := range servers {
+		out.Write([]string{
+			strconv.Itoa(item.Game_id),
+			item.Name,
+			item.Description,
+			item.Host_address,
+			item.Application_version.Game_version,
+			strconv.Itoa(len(item.Players)),
+			strings.Join(item.Players, ";"),
+			strconv.Itoa(item.Mod_count),
+			strconv.FormatBool(item.Has_password),
+			strconv.Itoa(getMinutes(item)),
+			strings.Join(item.Tags, ";"),
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// Self-signed certificate, for testing or until a real one is set up
+func runGenCert(fs *flag.FlagSet, opts *cliOptions) error {
+	if !*certForce {
+		for _, name := range []string{"fullchain.pem", "privkey.pem"} {
+			if _, err := os.Stat(certPath(name)); err == nil {
+				return fmt.Errorf("certificate already exists in %v, use -force to replace it", certDir)
+			}
+		}
+	}
+	if *certDays < 1 {
+		return errors.New("-days must be at least 1")
+	}
+
+	var hosts []string
+	for _, host := range strings.Split(*certHosts, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	if len(hosts) == 0 {
+		return errors.New("-hosts is empty")
+	}
+
+	if err := generateSelfSignedCert(hosts, time.Duration(*certDays)*24*time.Hour); err != nil {
+		return err
+	}
+	//Make sure serve can load what we wrote
+	if err := reloadCerts(); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote self-signed certificate for %v to %v.\n", strings.Join(hosts, ", "), certDir)
+	return nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFindCommand(t *testing.T) {
+	for _, name := range []string{"serve", "fetch-once", "inspect-cache", "export", "gen-cert"} {
+		if cmd := findCommand(name); cmd == nil || cmd.Name != name {
+			t.Fatalf("expected to find command %q", name)
+		}
+	}
+	if findCommand("nope") != nil {
+		t.Fatal("expected nil for unknown command")
+	}
+}
+
+func TestFetchOnceWritesCache(t *testing.T) {
+	setupDurafmt()
+	restore := configureFetchTestState(t)
+	defer restore()
+	restoreCache := configureCacheTestState(t)
+	defer restoreCache()
+	oldRetention := historyRetention
+	historyRetention = nil
+	defer func() { historyRetention = oldRetention }()
+
+	path := filepath.Join(t.TempDir(), "list.json")
+	writeTestFile(t, path, makeServerListJSON(MinValidCount+3))
+	empty := ""
+	opts := &cliOptions{sourceFile: &path, replayDir: &empty, peers: &empty, retention: &empty}
+
+	if err := runFetchOnce(nil, opts); err != nil {
+		t.Fatalf("runFetchOnce returned error: %v", err)
+	}
+	cache, _, err := readCacheFile(CacheFile)
+	if err != nil {
+		t.Fatalf("expected a valid cache, got %v", err)
+	}
+	if len(cache.Servers) != MinValidCount+3 {
+		t.Fatalf("expected %d cached servers, got %d", MinValidCount+3, len(cache.Servers))
+	}
+}
+
+func TestInspectCacheFile(t *testing.T) {
+	setupDurafmt()
+	restore := configureCacheTestState(t)
+	defer restore()
+
+	WriteServerCache(newSnapshot(processServerList(seedServers(MinValidCount+1)), time.Now()))
+	info, err := os.Stat(CacheFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	inspectCacheFile(&buf, CacheFile, info)
+	out := buf.String()
+	for _, want := range []string{"Checksum:  ok", "Servers:   26", "Players:   26", "2.0.1"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in output:\n%v", want, out)
+		}
+	}
+
+	//Damage a server without updating the checksum
+	body, _ := os.ReadFile(CacheFile)
+	writeTestFile(t, CacheFile, strings.Replace(string(body), "seed-1", "seed-X", 1))
+	buf.Reset()
+	inspectCacheFile(&buf, CacheFile, info)
+	if !strings.Contains(buf.String(), "Checksum:  BAD") {
+		t.Fatalf("expected bad checksum, got:\n%v", buf.String())
+	}
+}
+
+func TestExportFormats(t *testing.T) {
+	setupDurafmt()
+	restore := configureCacheTestState(t)
+	defer restore()
+
+	count := MinValidCount + 1
+	WriteServerCache(newSnapshot(processServerList(seedServers(count)), time.Now()))
+
+	format, out := "", filepath.Join(t.TempDir(), "export")
+	exportFormat, exportOut = &format, &out
+	defer func() { exportFormat, exportOut = nil, nil }()
+
+	export := func(name string) []byte {
+		t.Helper()
+		format = name
+		if err := runExport(nil, nil); err != nil {
+			t.Fatalf("export %v returned error: %v", name, err)
+		}
+		body, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return body
+	}
+
+	var cache CacheData
+	if err := json.Unmarshal(export("json"), &cache); err != nil {
+		t.Fatalf("json export doesn't parse: %v", err)
+	}
+	if len(cache.Servers) != count {
+		t.Fatalf("expected %d servers in json, got %d", count, len(cache.Servers))
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(export("ndjson"))), "\n")
+	if len(lines) != count {
+		t.Fatalf("expected %d ndjson lines, got %d", count, len(lines))
+	}
+	var item ServerListItem
+	if err := json.Unmarshal([]byte(lines[0]), &item); err != nil || item.Name == "" {
+		t.Fatalf("expected a server per line, got %q (%v)", lines[0], err)
+	}
+
+	rows, err := csv.NewReader(bytes.NewReader(export("csv"))).ReadAll()
+	if err != nil {
+		t.Fatalf("csv export doesn't parse: %v", err)
+	}
+	if len(rows) != count+1 || rows[0][1] != "name" {
+		t.Fatalf("expected header and %d rows, got %d", count, len(rows))
+	}
+	if rows[1][4] != "2.0.1" || rows[1][6] != "seed-player" {
+		t.Fatalf("unexpected csv row %v", rows[1])
+	}
+
+	format = "xml"
+	if err := runExport(nil, nil); err == nil {
+		t.Fatal("expected error for unknown format")
+	}
+}
+
+func TestGenCert(t *testing.T) {
+	oldDir := certDir
+	certDir = filepath.Join(t.TempDir(), "certs")
+	defer func() { certDir = oldDir }()
+
+	hosts, days, force := "localhost, 127.0.0.1", 30, false
+	certHosts, certDays, certForce = &hosts, &days, &force
+	defer func() { certHosts, certDays, certForce = nil, nil, nil }()
+
+	if err := runGenCert(nil, nil); err != nil {
+		t.Fatalf("runGenCert returned error: %v", err)
+	}
+	cert, err := getCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := cert.Leaf
+	if leaf == nil || len(leaf.DNSNames) != 1 || len(leaf.IPAddresses) != 1 {
+		t.Fatalf("expected localhost and 127.0.0.1 in the certificate, got %+v", leaf)
+	}
+	if leaf.NotAfter.Sub(time.Now()) > 31*24*time.Hour {
+		t.Fatalf("expected 30 days validity, got %v", leaf.NotAfter)
+	}
+	if info, err := os.Stat(certPath("privkey.pem")); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("expected private key mode 0600, got %v (%v)", info.Mode().Perm(), err)
+	}
+
+	if err := runGenCert(nil, nil); err == nil {
+		t.Fatal("expected error replacing a certificate without -force")
+	}
+	force = true
+	if err := runGenCert(nil, nil); err != nil {
+		t.Fatalf("expected -force to replace the certificate, got %v", err)
+	}
+}
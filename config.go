@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"goFactServView/cwlog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"unicode"
+)
+
+// Environment variables are this plus the flag name, like GOFACTSERVVIEW_HTTPS_PORT
+const EnvPrefix = "GOFACTSERVVIEW_"
+
+var (
+	//JSON object of settings, keyed by flag name
+	configPath = "data/config.json"
+	//Read the API token from here, so it isn't on the command line
+	tokenFile string
+
+	//Flags given on the command line, these always win
+	cmdlineFlags = map[string]bool{}
+
+	//Settings a SIGHUP reloads, the rest need a restart
+	reloadableSettings = map[string]bool{
+		"token":         true,
+		"token-file":    true,
+		"username":      true,
+		"maxQuarantine": true,
+		"maxDrop":       true,
+		"peerFailures":  true,
+		"peerMaxAge":    true,
+	}
+)
+
+// Fill in flags not given on the command line, from the environment,
+// then the config file, then their defaults.
+// only limits which settings change, nil means all of them.
+// On error nothing is changed.
+func loadConfig(fs *flag.FlagSet, only map[string]bool) error {
+	path, required := configPath, cmdlineFlags["config"]
+	if env, found := os.LookupEnv(envName("config")); found && !required {
+		path, required = env, true
+	}
+	values, err := readConfigFile(path, required)
+	if err != nil {
+		return err
+	}
+	for name := range values {
+		if name == "config" || fs.Lookup(name) == nil {
+			return fmt.Errorf("%v: unknown setting %q", path, name)
+		}
+	}
+
+	//Kept to roll back to, if anything fails
+	oldValues := map[*flag.Flag]string{}
+	var oldToken *string
+	if upstream.Token != nil {
+		token := *upstream.Token
+		oldToken = &token
+	}
+
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" || cmdlineFlags[f.Name] || (only != nil && !only[f.Name]) {
+			return
+		}
+		value, found := values[f.Name]
+		if env, ok := os.LookupEnv(envName(f.Name)); ok {
+			value, found = env, true
+		}
+		if !found {
+			value = f.DefValue
+		}
+		oldValues[f] = f.Value.String()
+		if err := f.Value.Set(value); err != nil {
+			errs = append(errs, fmt.Errorf("setting %v: %w", f.Name, err))
+		}
+	})
+	if len(errs) == 0 {
+		if err := loadTokenFile(); err != nil {
+			errs = append(errs, err)
+		} else if err := validateConfig(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		for f, value := range oldValues {
+			f.Value.Set(value)
+		}
+		if oldToken != nil {
+			*upstream.Token = *oldToken
+		}
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// Settings from a config file, as flag strings
+func readConfigFile(path string, required bool) (map[string]string, error) {
+	body, err := os.ReadFile(path)
+	if os.IsNotExist(err) && !required {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	raw := map[string]any{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+
+	values := map[string]string{}
+	for name, value := range raw {
+		switch v := value.(type) {
+		case string:
+			values[name] = v
+		case json.Number:
+			values[name] = v.String()
+		case bool:
+			values[name] = strconv.FormatBool(v)
+		default:
+			return nil, fmt.Errorf("%v: setting %q must be a string, number or bool", path, name)
+		}
+	}
+	return values, nil
+}
+
+// Environment variable for a flag: httpsPort is GOFACTSERVVIEW_HTTPS_PORT
+func envName(flagName string) string {
+	var buf strings.Builder
+	buf.WriteString(EnvPrefix)
+	for i, r := range flagName {
+		switch {
+		case r == '-':
+			buf.WriteByte('_')
+		case unicode.IsUpper(r) && i > 0:
+			buf.WriteByte('_')
+			buf.WriteRune(r)
+		default:
+			buf.WriteRune(unicode.ToUpper(r))
+		}
+	}
+	return buf.String()
+}
+
+// Token from -token-file, unless -token was given on the command line
+func loadTokenFile() error {
+	if tokenFile == "" || cmdlineFlags["token"] {
+		return nil
+	}
+	body, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return fmt.Errorf("reading token file: %w", err)
+	}
+	token := strings.TrimSpace(string(body))
+	if token == "" {
+		return fmt.Errorf("token file %v is empty", tokenFile)
+	}
+	*upstream.Token = token
+	return nil
+}
+
+func validateConfig() error {
+	if ItemsPerPage < 1 {
+		return fmt.Errorf("itemsPerPage must be at least 1")
+	}
+	if MinValidCount < 0 {
+		return fmt.Errorf("minValidCount can't be negative")
+	}
+	if FuzzyTolerance < 0 || FuzzyTolerance > MaxFuzzyTolerance {
+		return fmt.Errorf("fuzzyTolerance must be 0 to %v", MaxFuzzyTolerance)
+	}
+	if RefreshInterval <= 0 || BGFetchInterval <= 0 || ReqTimeout <= 0 || peerMaxAge <= 0 {
+		return fmt.Errorf("intervals and timeouts must be positive")
+	}
+	return nil
+}
+
+// Reload runtime settings on SIGHUP
+func watchConfigReload(fs *flag.FlagSet) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+
+	for range sig {
+		//Nothing reads these settings while both are held
+		RefreshLock.Lock()
+		DetailLock.Lock()
+		err := loadConfig(fs, reloadableSettings)
+		DetailLock.Unlock()
+		RefreshLock.Unlock()
+
+		if err != nil {
+			cwlog.DoLog(true, "Config reload failed: %v", err)
+			continue
+		}
+		cwlog.DoLog(true, "Reloaded config.")
+	}
+}
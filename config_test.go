@@ -0,0 +1,170 @@
+package main
+
+import (
+	"flag"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type configTestFlags struct {
+	fs       *flag.FlagSet
+	port     int
+	url      string
+	interval time.Duration
+	token    string
+}
+
+func configureConfigTestState(t *testing.T, body string, args ...string) *configTestFlags {
+	t.Helper()
+
+	oldPath, oldCmdline, oldTokenFile, oldUpstream := configPath, cmdlineFlags, tokenFile, upstream
+	oldMaxAge := peerMaxAge
+	t.Cleanup(func() {
+		configPath, cmdlineFlags, tokenFile, upstream = oldPath, oldCmdline, oldTokenFile, oldUpstream
+		peerMaxAge = oldMaxAge
+	})
+
+	dir := t.TempDir()
+	configPath = filepath.Join(dir, "config.json")
+	if body != "" {
+		writeTestFile(t, configPath, body)
+	}
+	cmdlineFlags = map[string]bool{}
+	tokenFile = ""
+
+	f := &configTestFlags{fs: flag.NewFlagSet("test", flag.ContinueOnError)}
+	f.fs.IntVar(&f.port, "httpsPort", 443, "")
+	f.fs.StringVar(&f.url, "url", "multiplayer.factorio.com", "")
+	f.fs.DurationVar(&f.interval, "refreshInterval", 5*time.Minute, "")
+	f.fs.StringVar(&f.token, "token", "", "")
+	f.fs.StringVar(&tokenFile, "token-file", "", "")
+	f.fs.DurationVar(&peerMaxAge, "peerMaxAge", 15*time.Minute, "")
+	upstream.Token = &f.token
+
+	if err := f.fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	f.fs.Visit(func(fl *flag.Flag) { cmdlineFlags[fl.Name] = true })
+	return f
+}
+
+func TestLoadConfigLayers(t *testing.T) {
+	f := configureConfigTestState(t,
+		`{"httpsPort": 8443, "url": "file.example", "refreshInterval": "10m"}`,
+		"-url", "flag.example")
+	t.Setenv("GOFACTSERVVIEW_REFRESH_INTERVAL", "2m")
+
+	if err := loadConfig(f.fs, nil); err != nil {
+		t.Fatalf("loadConfig returned error: %v", err)
+	}
+	if f.port != 8443 {
+		t.Fatalf("expected port from config file, got %d", f.port)
+	}
+	if f.interval != 2*time.Minute {
+		t.Fatalf("expected interval from environment, got %v", f.interval)
+	}
+	if f.url != "flag.example" {
+		t.Fatalf("expected url from command line, got %q", f.url)
+	}
+}
+
+func TestLoadConfigReloadOnlyTouchesReloadable(t *testing.T) {
+	f := configureConfigTestState(t, `{"httpsPort": 8443}`)
+	if err := loadConfig(f.fs, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFile(t, configPath, `{"httpsPort": 9443, "token": "new-token"}`)
+	if err := loadConfig(f.fs, reloadableSettings); err != nil {
+		t.Fatal(err)
+	}
+	if f.port != 8443 {
+		t.Fatalf("expected port to need a restart, got %d", f.port)
+	}
+	if f.token != "new-token" {
+		t.Fatalf("expected token to reload, got %q", f.token)
+	}
+
+	//Removed from the file, back to the default
+	writeTestFile(t, configPath, `{}`)
+	if err := loadConfig(f.fs, reloadableSettings); err != nil {
+		t.Fatal(err)
+	}
+	if f.token != "" {
+		t.Fatalf("expected token reset to default, got %q", f.token)
+	}
+}
+
+func TestLoadConfigInvalidReloadChangesNothing(t *testing.T) {
+	f := configureConfigTestState(t, `{"token": "old-token"}`)
+	if err := loadConfig(f.fs, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	//The token is fine on its own, peerMaxAge fails validation
+	writeTestFile(t, configPath, `{"token": "new-token", "peerMaxAge": "-1m"}`)
+	if err := loadConfig(f.fs, reloadableSettings); err == nil {
+		t.Fatal("expected invalid reload to fail")
+	}
+	if f.token != "old-token" || peerMaxAge != 15*time.Minute {
+		t.Fatalf("expected settings left alone, got token %q and peerMaxAge %v", f.token, peerMaxAge)
+	}
+}
+
+func TestLoadConfigTokenFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	writeTestFile(t, path, "secret-token\n")
+
+	f := configureConfigTestState(t, "", "-token-file", path)
+	if err := loadConfig(f.fs, nil); err != nil {
+		t.Fatalf("loadConfig returned error: %v", err)
+	}
+	if f.token != "secret-token" {
+		t.Fatalf("expected token from file, got %q", f.token)
+	}
+
+	//-token on the command line wins
+	f = configureConfigTestState(t, "", "-token-file", path, "-token", "flag-token")
+	if err := loadConfig(f.fs, nil); err != nil {
+		t.Fatal(err)
+	}
+	if f.token != "flag-token" {
+		t.Fatalf("expected token from command line, got %q", f.token)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	f := configureConfigTestState(t, `{"nope": 1}`)
+	if err := loadConfig(f.fs, nil); err == nil || !strings.Contains(err.Error(), "unknown setting") {
+		t.Fatalf("expected unknown setting error, got %v", err)
+	}
+
+	f = configureConfigTestState(t, `{"httpsPort": "abc"}`)
+	if err := loadConfig(f.fs, nil); err == nil {
+		t.Fatal("expected error for invalid value")
+	}
+
+	//No file written, fine unless -config named it
+	f = configureConfigTestState(t, "")
+	if err := loadConfig(f.fs, nil); err != nil {
+		t.Fatalf("expected missing default config to be fine, got %v", err)
+	}
+	cmdlineFlags["config"] = true
+	if err := loadConfig(f.fs, nil); err == nil {
+		t.Fatal("expected error for missing config file given on the command line")
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	for name, want := range map[string]string{
+		"httpsPort":  "GOFACTSERVVIEW_HTTPS_PORT",
+		"token-file": "GOFACTSERVVIEW_TOKEN_FILE",
+		"ip":         "GOFACTSERVVIEW_IP",
+	} {
+		if got := envName(name); got != want {
+			t.Fatalf("envName(%q): expected %q, got %q", name, want, got)
+		}
+	}
+}
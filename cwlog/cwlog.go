@@ -10,6 +10,9 @@ import (
 )
 
 var (
+	/* Where log files go, set before StartLog */
+	LogDir = "data/log"
+
 	logDesc  *os.File
 	logName  string
 	logReady bool
@@ -96,10 +99,10 @@ func StartLog() {
 	t := time.Now()
 
 	/* Create our log file names */
-	logName = fmt.Sprintf("data/log/auth-%v-%v-%v.log", t.Day(), t.Month(), t.Year())
+	logName = filepath.Join(LogDir, fmt.Sprintf("auth-%v-%v-%v.log", t.Day(), t.Month(), t.Year()))
 
 	/* Make log directory */
-	errr := os.MkdirAll("data/log", os.ModePerm)
+	errr := os.MkdirAll(LogDir, os.ModePerm)
 	if errr != nil {
 		fmt.Print(errr.Error())
 		return
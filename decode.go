@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Most bytes we'll read from any server list source
+var maxBodySize int64 = 64 << 20
+
+// Servers decoded at a time, before joining them into one list
+const decodeChunkSize = 1024
+
+var errBodyTooLarge = errors.New("response body too large")
+
+// Errors once more than Max bytes have been read
+type boundedReader struct {
+	R    io.Reader
+	Max  int64
+	read int64
+}
+
+func (br *boundedReader) Read(p []byte) (int, error) {
+	//Allow one byte past the limit, so we can tell a body
+	//of exactly Max bytes from one that's too large
+	if left := br.Max + 1 - br.read; int64(len(p)) > left {
+		p = p[:left]
+	}
+	n, err := br.R.Read(p)
+	br.read += int64(n)
+	if br.read > br.Max {
+		return n, fmt.Errorf("%w: over %d bytes", errBodyTooLarge, br.Max)
+	}
+	return n, err
+}
+
+// Decode a get-games response or a cache file,
+// one server at a time, reading at most maxBodySize bytes
+func decodeServerList(r io.Reader) ([]ServerListItem, error) {
+	cache, err := decodeCacheData(r)
+	return cache.Servers, err
+}
+
+// Decode a get-games response, a cache file or a peer snapshot. Only the
+// servers are streamed, the rest of a cache object is small.
+func decodeCacheData(r io.Reader) (CacheData, error) {
+	dec := json.NewDecoder(&boundedReader{R: r, Max: maxBodySize})
+
+	//Cache files are an object, get-games is an array
+	cache := CacheData{}
+	tok, err := dec.Token()
+	if err == nil {
+		switch tok {
+		case json.Delim('['):
+			cache.Servers, err = decodeServerItems(dec)
+		case json.Delim('{'):
+			err = decodeCacheFields(dec, &cache)
+		default:
+			err = fmt.Errorf("expected a list of servers, got %v", tok)
+		}
+	}
+	if err != nil {
+		return CacheData{}, invalidJSON(err)
+	}
+
+	//Nothing else after it
+	if _, err := dec.Token(); err != io.EOF {
+		if err == nil {
+			err = errors.New("trailing data after server list")
+		}
+		return CacheData{}, invalidJSON(err)
+	}
+	return cache, nil
+}
+
+// The fields of a cache object, after its opening {
+func decodeCacheFields(dec *json.Decoder, cache *CacheData) error {
+	header := map[string]json.RawMessage{}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, _ := tok.(string)
+		if !strings.EqualFold(key, "Servers") {
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return err
+			}
+			header[key] = raw
+			continue
+		}
+
+		tok, err = dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case nil:
+			cache.Servers = nil
+		case json.Delim('['):
+			if cache.Servers, err = decodeServerItems(dec); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("expected a list of servers, got %v", tok)
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+
+	//Everything but the servers, in one go
+	body, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	servers := cache.Servers
+	if err := json.Unmarshal(body, cache); err != nil {
+		return err
+	}
+	cache.Servers = servers
+	return nil
+}
+
+// Servers one at a time, after the opening [ of the list
+func decodeServerItems(dec *json.Decoder) ([]ServerListItem, error) {
+	//Decode into fixed size chunks, so big entries aren't copied
+	//each time the list grows, then join them once at the end
+	var chunks [][]ServerListItem
+	chunk := make([]ServerListItem, 0, decodeChunkSize)
+	count := 0
+	for dec.More() {
+		if len(chunk) == cap(chunk) {
+			chunks = append(chunks, chunk)
+			chunk = make([]ServerListItem, 0, decodeChunkSize)
+		}
+		chunk = append(chunk, ServerListItem{})
+		item := &chunk[len(chunk)-1]
+		count++
+		if err := dec.Decode(item); err != nil {
+			//A wrongly typed field still reads the whole entry, so it's
+			//flagged for quarantine and the rest of the list is still fine
+			var typeErr *json.UnmarshalTypeError
+			if !errors.As(err, &typeErr) {
+				return nil, fmt.Errorf("at server %d: %w", count, err)
+			}
+			item.decodeErr = fmt.Errorf("invalid entry: %w", err)
+		}
+	}
+
+	//Closing ]
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	newServerList := make([]ServerListItem, 0, count)
+	for _, full := range append(chunks, chunk) {
+		newServerList = append(newServerList, full...)
+	}
+	return newServerList, nil
+}
+
+// Hitting the size limit isn't a JSON problem
+func invalidJSON(err error) error {
+	if errors.Is(err, errBodyTooLarge) {
+		return err
+	}
+	return fmt.Errorf("invalid JSON: %w", err)
+}
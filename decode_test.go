@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDecodeServerListStreams(t *testing.T) {
+	list, err := decodeServerList(strings.NewReader(" \n" + makeServerListJSON(3)))
+	if err != nil {
+		t.Fatalf("decodeServerList returned error: %v", err)
+	}
+	if len(list) != 3 || list[2].Host_address != "127.0.0.1:3003" {
+		t.Fatalf("unexpected list: %+v", list)
+	}
+}
+
+func TestDecodeCacheDataStreamsObject(t *testing.T) {
+	body := `{"Version":4,"ETag":"\"v1\"","Servers":[{"Name":"a","Host_address":"127.0.0.1:1"},
+		{"Name":7}],"Mirrored":true,"FetchedAt":"2024-01-02T03:04:05Z"}`
+	cache, err := decodeCacheData(strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("decodeCacheData returned error: %v", err)
+	}
+	if cache.Version != 4 || cache.ETag != `"v1"` || !cache.Mirrored || cache.FetchedAt.Year() != 2024 {
+		t.Fatalf("unexpected cache fields: %+v", cache)
+	}
+	if len(cache.Servers) != 2 || cache.Servers[0].Name != "a" || cache.Servers[1].decodeErr == nil {
+		t.Fatalf("expected one good server and one flagged, got %+v", cache.Servers)
+	}
+
+	if cache, err := decodeCacheData(strings.NewReader(`{"Version":4,"Servers":null}`)); err != nil || cache.Servers != nil {
+		t.Fatalf("expected no servers, got %+v (%v)", cache.Servers, err)
+	}
+	for _, body := range []string{`{"Servers":42}`, `{"Servers":[]`, `{"Version":"x","Servers":[]}`} {
+		if _, err := decodeCacheData(strings.NewReader(body)); err == nil {
+			t.Fatalf("expected error for %q", body)
+		}
+	}
+}
+
+func TestDecodeServerListRejectsOversizedBody(t *testing.T) {
+	oldMax := maxBodySize
+	defer func() { maxBodySize = oldMax }()
+
+	body := makeServerListJSON(10)
+	maxBodySize = int64(len(body))
+	if _, err := decodeServerList(strings.NewReader(body)); err != nil {
+		t.Fatalf("expected body at the limit to decode, got %v", err)
+	}
+
+	maxBodySize = int64(len(body)) - 1
+	_, err := decodeServerList(strings.NewReader(body))
+	if !errors.Is(err, errBodyTooLarge) {
+		t.Fatalf("expected errBodyTooLarge, got %v", err)
+	}
+
+	//The same limit applies to cache objects
+	cacheBody := `{"Version":4,"Servers":` + body + `}`
+	maxBodySize = int64(len(cacheBody)) - 1
+	if _, err := decodeServerList(strings.NewReader(cacheBody)); !errors.Is(err, errBodyTooLarge) {
+		t.Fatalf("expected errBodyTooLarge for a cache object, got %v", err)
+	}
+}
+
+func TestDecodeServerListRejectsBadShapes(t *testing.T) {
+	for _, body := range []string{"", "42", `[{"Name":"a"}] [`, `[{"Name":"a"},`} {
+		if _, err := decodeServerList(strings.NewReader(body)); err == nil {
+			t.Fatalf("expected error for %q", body)
+		}
+	}
+}
+
+var benchPayload = func() []byte {
+	//Compact, like matchmaking sends
+	buf := bytes.Buffer{}
+	json.Compact(&buf, []byte(makeServerListJSON(20000)))
+	return buf.Bytes()
+}()
+
+func BenchmarkDecodeServerListStream(b *testing.B) {
+	stream := func() []ServerListItem {
+		list, err := decodeServerList(bytes.NewReader(benchPayload))
+		if err != nil {
+			b.Fatal(err)
+		}
+		return list
+	}
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchPayload)))
+	for b.Loop() {
+		stream()
+	}
+	reportDecodeHeap(b, stream)
+}
+
+// The old io.ReadAll + json.Unmarshal approach, for comparison
+func BenchmarkDecodeServerListReadAll(b *testing.B) {
+	readAll := func() []ServerListItem {
+		body, err := io.ReadAll(bytes.NewReader(benchPayload))
+		if err != nil {
+			b.Fatal(err)
+		}
+		list := []ServerListItem{}
+		if err := json.Unmarshal(body, &list); err != nil {
+			b.Fatal(err)
+		}
+		return list
+	}
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchPayload)))
+	for b.Loop() {
+		readAll()
+	}
+	reportDecodeHeap(b, readAll)
+}
+
+// Report the peak live heap while decoding once, and what's still in use
+// afterwards while the list is kept. B/op only counts allocations.
+// Call after the b.Loop, which would clear these.
+func reportDecodeHeap(b *testing.B, decode func() []ServerListItem) {
+	b.Helper()
+
+	//Collect often, so garbage doesn't hide in the peak
+	defer debug.SetGCPercent(debug.SetGCPercent(5))
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	heap := func() uint64 {
+		metrics.Read(sample)
+		return sample[0].Value.Uint64()
+	}
+
+	runtime.GC()
+	base := heap()
+	var peak atomic.Uint64
+	done := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		for {
+			if now := heap(); now > peak.Load() {
+				peak.Store(now)
+			}
+			select {
+			case <-done:
+				return
+			default:
+			}
+		}
+	}()
+
+	list := decode()
+	close(done)
+	<-sampled
+	//The list itself, in case the sampler missed the end
+	if now := heap(); now > peak.Load() {
+		peak.Store(now)
+	}
+	runtime.GC()
+	retained := heap()
+	runtime.KeepAlive(list)
+
+	b.ReportMetric(float64(peak.Load()-base), "peak-B")
+	b.ReportMetric(float64(retained-base), "retained-B")
+}
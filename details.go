@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goFactServView/cwlog"
+	"html/template"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type detailCacheItem struct {
+	Details     *ServerDetailsData
+	LastRefresh time.Time
+	LastAttempt time.Time
+}
+
+var (
+	DetailLock        sync.Mutex
+	detailCache       = map[int]*detailCacheItem{}
+	lastDetailRequest time.Time
+
+	errDetailThrottled = errors.New("server details were requested too recently, try again shortly")
+)
+
+// Get details for a single server, from cache if fresh enough
+func fetchServerDetails(gameID int) (*ServerDetailsData, error) {
+	if offline {
+		return nil, errOffline
+	}
+
+	DetailLock.Lock()
+	item := detailCache[gameID]
+	if item == nil {
+		item = &detailCacheItem{}
+	}
+
+	//Don't refresh unless enough time has passed
+	if item.Details != nil && time.Since(item.LastRefresh) < RefreshInterval {
+		DetailLock.Unlock()
+		return item.Details, nil
+	}
+
+	//Don't attempt if we attempted this server recently,
+	//or any server very recently
+	cached := item.Details
+	if time.Since(item.LastAttempt) < ReqThrottle ||
+		time.Since(lastDetailRequest) < DetailThrottle {
+		DetailLock.Unlock()
+		if cached != nil {
+			return cached, nil
+		}
+		return nil, errDetailThrottled
+	}
+
+	//Leave upstream alone while the list fetch is backing off
+	if cb := getBreaker(); cb.State == BREAKER_OPEN && time.Now().Before(cb.OpenUntil) {
+		DetailLock.Unlock()
+		if cached != nil {
+			return cached, nil
+		}
+		return nil, cb.openErr()
+	}
+	item.LastAttempt = time.Now().UTC()
+	lastDetailRequest = item.LastAttempt
+	storeDetailItem(gameID, item)
+	DetailLock.Unlock()
+
+	//Don't hold the lock while waiting on upstream
+	details, err := requestServerDetails(gameID)
+	if err != nil {
+		return cached, err
+	}
+
+	DetailLock.Lock()
+	item.Details = details
+	item.LastRefresh = details.Local.FetchedAt
+	storeDetailItem(gameID, item)
+	DetailLock.Unlock()
+
+	cwlog.DoLog(false, "Fetched details for game %v", gameID)
+	return details, nil
+}
+
+// HTTP GET the details of one server from upstream
+func requestServerDetails(gameID int) (*ServerDetailsData, error) {
+	req, err := http.NewRequest(http.MethodGet, buildDetailsURL(*upstream.URL, gameID), nil)
+	if err != nil {
+		cwlog.DoLog(true, "fetchServerDetails: request build failed: %v", err)
+		return nil, err
+	}
+
+	req.Header.Set("User-Agent", UserAgent)
+
+	//Get response
+	res, getErr := fetchHTTPClient().Do(req)
+	if getErr != nil {
+		cwlog.DoLog(true, "fetchServerDetails: request failed: %v", getErr)
+		return nil, getErr
+	}
+
+	//Close once complete, if valid
+	if res.Body != nil {
+		defer res.Body.Close()
+	}
+
+	if res.StatusCode != http.StatusOK {
+		errBody, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		err := &upstreamStatusError{Code: res.StatusCode, Body: shortenBody(errBody)}
+		cwlog.DoLog(true, "fetchServerDetails: %v", err)
+		return nil, err
+	}
+
+	//Read all, up to the same limit as the server list
+	body, readErr := io.ReadAll(&boundedReader{R: res.Body, Max: maxBodySize})
+	if readErr != nil {
+		cwlog.DoLog(true, "fetchServerDetails: read failed: %v", readErr)
+		return nil, readErr
+	}
+
+	details := &ServerDetailsData{}
+	jsonErr := json.Unmarshal(body, details)
+	if jsonErr != nil {
+		cwlog.DoLog(true, "fetchServerDetails: invalid JSON: %v", jsonErr)
+		return nil, jsonErr
+	}
+	if details.Game_id != gameID {
+		err := fmt.Errorf("upstream returned game %d, wanted %d", details.Game_id, gameID)
+		cwlog.DoLog(true, "fetchServerDetails: %v", err)
+		return nil, err
+	}
+
+	processServerDetails(details)
+	return details, nil
+}
+
+// Convert some of the data for web
+func processServerDetails(details *ServerDetailsData) {
+	details.Local.NameHTML = RichTextHTML(details.Name)
+	details.Local.DescHTML = RichTextHTML(details.Description)
+
+	name := RemoveFactorioTags(details.Name)
+	//If name is only tags, allow it.
+	if name == "" {
+		name = details.Name
+	}
+	if name == "" {
+		name = "Unnamed Server"
+		details.Local.NameHTML = template.HTML(name)
+	}
+	details.Name = name
+	details.Description = RemoveFactorioTags(details.Description)
+	for t, tag := range details.Tags {
+		details.Tags[t] = RemoveFactorioTags(tag)
+	}
+
+	details.Local.ConnectURL = MakeSteamURL(details.Host_address)
+	details.Local.Players = len(details.Players)
+	details.Local.TimeStr = updateTime(getMinutes(ServerListItem{Game_time_elapsed: details.Game_time_elapsed}))
+	if details.Last_heartbeat > 0 {
+		heartbeat := time.Unix(int64(details.Last_heartbeat), 0).UTC()
+		details.Local.HeartbeatStr = heartbeat.Format(time.RFC1123)
+	}
+	details.Local.FetchedAt = time.Now().UTC()
+	details.Local.FetchedStr = details.Local.FetchedAt.Format(time.RFC1123)
+}
+
+// Add to the cache, evicting the oldest entry if full
+func storeDetailItem(gameID int, item *detailCacheItem) {
+	if _, found := detailCache[gameID]; !found && len(detailCache) >= DetailCacheSize {
+		oldestID := 0
+		var oldest time.Time
+		for id, cached := range detailCache {
+			if oldest.IsZero() || cached.LastAttempt.Before(oldest) {
+				oldestID = id
+				oldest = cached.LastAttempt
+			}
+		}
+		delete(detailCache, oldestID)
+	}
+	detailCache[gameID] = item
+}
+
+func buildDetailsURL(baseURL string, gameID int) string {
+	return buildBaseURL(baseURL) + "/get-game-details/" + strconv.Itoa(gameID)
+}
+
+// Check the game ID is in our current list
+func knownGameID(gameID int) bool {
+	for _, server := range getSnapshot().Servers {
+		if server.Game_id == gameID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFetchServerDetailsCachesResult(t *testing.T) {
+	setupDurafmt()
+
+	//t.Fatal can't be called from the handler's goroutine
+	var handlerLock sync.Mutex
+	var handlerErrs []string
+	hits := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerLock.Lock()
+		defer handlerLock.Unlock()
+		hits++
+		if r.URL.Path != "/get-game-details/42" {
+			handlerErrs = append(handlerErrs, "unexpected path: "+r.URL.Path)
+		}
+		if got := r.Header.Get("User-Agent"); got != UserAgent {
+			handlerErrs = append(handlerErrs, "unexpected user agent: "+got)
+		}
+		//Other requests shouldn't wait on upstream
+		if !DetailLock.TryLock() {
+			handlerErrs = append(handlerErrs, "DetailLock held during the upstream request")
+		} else {
+			DetailLock.Unlock()
+		}
+		fmt.Fprint(w, `{
+			"game_id":42,
+			"name":"[color=red]Detail Server[/color]",
+			"host_address":"127.0.0.1:34197",
+			"max_players":16,
+			"last_heartbeat":1700000000.5,
+			"server_id":"abc123",
+			"mods":[{"name":"base","version":"2.0.0"}],
+			"players":["a","b"]
+		}`)
+	}))
+	defer server.Close()
+
+	restore := configureDetailTestState(t)
+	defer restore()
+
+	*upstream.URL = server.URL
+	fetchHTTPClient = func() *http.Client {
+		return server.Client()
+	}
+
+	details, err := fetchServerDetails(42)
+	handlerLock.Lock()
+	if len(handlerErrs) > 0 {
+		t.Fatalf("handler: %v", strings.Join(handlerErrs, ", "))
+	}
+	handlerLock.Unlock()
+	if err != nil {
+		t.Fatalf("fetchServerDetails returned error: %v", err)
+	}
+	if details.Max_players != 16 || details.Server_id != "abc123" || len(details.Mods) != 1 {
+		t.Fatalf("unexpected details: %+v", details)
+	}
+	if strings.Contains(details.Name, "[") {
+		t.Fatalf("expected sanitized name, got %q", details.Name)
+	}
+	if details.Local.Players != 2 || details.Local.HeartbeatStr == "" {
+		t.Fatalf("expected local data to be filled in, got %+v", details.Local)
+	}
+
+	if _, err := fetchServerDetails(42); err != nil {
+		t.Fatalf("cached fetchServerDetails returned error: %v", err)
+	}
+	handlerLock.Lock()
+	defer handlerLock.Unlock()
+	if hits != 1 {
+		t.Fatalf("expected one upstream request, got %d", hits)
+	}
+}
+
+func TestFetchServerDetailsThrottled(t *testing.T) {
+	restore := configureDetailTestState(t)
+	defer restore()
+
+	lastDetailRequest = time.Now()
+	fetchHTTPClient = func() *http.Client {
+		t.Fatal("unexpected upstream request")
+		return nil
+	}
+
+	if _, err := fetchServerDetails(7); err != errDetailThrottled {
+		t.Fatalf("expected throttle error, got %v", err)
+	}
+}
+
+func TestFetchServerDetailsChecksStatusAndSize(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/get-game-details/1" {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `{"game_id":2,"name":"`+strings.Repeat("x", 100)+`"}`)
+	}))
+	defer server.Close()
+
+	restore := configureDetailTestState(t)
+	defer restore()
+	oldMax := maxBodySize
+	defer func() { maxBodySize = oldMax }()
+
+	*upstream.URL = server.URL
+	fetchHTTPClient = func() *http.Client {
+		return server.Client()
+	}
+
+	var statusErr *upstreamStatusError
+	if _, err := fetchServerDetails(1); !errors.As(err, &statusErr) || statusErr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected a 503 status error, got %v", err)
+	}
+
+	lastDetailRequest = time.Time{}
+	maxBodySize = 50
+	if _, err := fetchServerDetails(2); !errors.Is(err, errBodyTooLarge) {
+		t.Fatalf("expected errBodyTooLarge, got %v", err)
+	}
+}
+
+func TestFetchServerDetailsWaitsForBreaker(t *testing.T) {
+	restore := configureDetailTestState(t)
+	defer restore()
+
+	breakerStatus.Store(&circuitBreaker{State: BREAKER_OPEN, Failures: 3, OpenUntil: time.Now().Add(time.Hour)})
+	fetchHTTPClient = func() *http.Client {
+		t.Fatal("unexpected upstream request")
+		return nil
+	}
+
+	if _, err := fetchServerDetails(7); err == nil || !strings.Contains(err.Error(), "circuit open") {
+		t.Fatalf("expected circuit open error, got %v", err)
+	}
+}
+
+func configureDetailTestState(t *testing.T) func() {
+	t.Helper()
+
+	restoreFetch := configureFetchTestState(t)
+	oldCache := detailCache
+	oldLast := lastDetailRequest
+
+	detailCache = map[int]*detailCacheItem{}
+	lastDetailRequest = time.Time{}
+
+	return func() {
+		restoreFetch()
+		detailCache = oldCache
+		lastDetailRequest = oldLast
+	}
+}
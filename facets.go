@@ -0,0 +1,167 @@
+package main
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Most tags shown as facets
+const FacetTagCount = 10
+
+// Counts of a property over the filtered servers
+type Facet struct {
+	Name   string
+	Values []FacetValue
+}
+
+// One facet choice. Include and Exclude link to the list with its query term
+// added, or removed if it's already there.
+type FacetValue struct {
+	Label            string
+	Count            int
+	Active, Excluded bool
+	Include, Exclude string
+}
+
+// Facets for the filtered servers, linking from the current request's parameters
+func buildFacets(servers []ServerListItem, params url.Values) []Facet {
+	modded, password, populated := 0, 0, 0
+	tagCounts := map[string]int{}
+	tagLabels := map[string]string{}
+
+	for _, server := range servers {
+		if server.Local.Modded {
+			modded++
+		}
+		if server.Has_password {
+			password++
+		}
+		if server.Local.HasPlayers {
+			populated++
+		}
+		//Count each tag once per server
+		seen := map[string]bool{}
+		for _, tag := range server.Tags {
+			key := strings.ToLower(strings.TrimSpace(tag))
+			//The query language can't quote these
+			if key == "" || seen[key] || strings.Contains(key, `"`) {
+				continue
+			}
+			seen[key] = true
+			tagCounts[key]++
+			if _, found := tagLabels[key]; !found {
+				tagLabels[key] = strings.TrimSpace(tag)
+			}
+		}
+	}
+
+	tags := make([]string, 0, len(tagCounts))
+	for key := range tagCounts {
+		tags = append(tags, key)
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tagCounts[tags[i]] == tagCounts[tags[j]] {
+			return tags[i] < tags[j]
+		}
+		return tagCounts[tags[i]] > tagCounts[tags[j]]
+	})
+	if len(tags) > FacetTagCount {
+		tags = tags[:FacetTagCount]
+	}
+
+	tagFacet := Facet{Name: "Tags"}
+	for _, key := range tags {
+		//Exact, so the count matches what the link shows
+		term := "tag=" + key
+		if strings.IndexFunc(key, unicode.IsSpace) >= 0 {
+			term = `tag="` + key + `"`
+		}
+		tagFacet.Values = append(tagFacet.Values, facetValue(params, tagLabels[key], tagCounts[key], term))
+	}
+
+	total := len(servers)
+	facets := []Facet{
+		{Name: "Mods", Values: []FacetValue{
+			facetValue(params, "Modded", modded, "modded:yes"),
+			facetValue(params, "Vanilla", total-modded, "modded:no"),
+		}},
+		{Name: "Password", Values: []FacetValue{
+			facetValue(params, "Password", password, "password:yes"),
+			facetValue(params, "No password", total-password, "password:no"),
+		}},
+		{Name: "Players", Values: []FacetValue{
+			facetValue(params, "Populated", populated, "players>0"),
+			facetValue(params, "Empty", total-populated, "players:0"),
+		}},
+	}
+	if len(tagFacet.Values) > 0 {
+		facets = append([]Facet{tagFacet}, facets...)
+	}
+	return facets
+}
+
+func facetValue(params url.Values, label string, count int, term string) FacetValue {
+	query := params.Get("q")
+	value := FacetValue{
+		Label:    label,
+		Count:    count,
+		Active:   hasQueryTerm(query, term),
+		Excluded: hasQueryTerm(query, "-"+term),
+	}
+
+	if value.Active {
+		value.Include = facetURL(params, removeQueryTerm(query, term))
+	} else {
+		value.Include = facetURL(params, addQueryTerm(removeQueryTerm(query, "-"+term), term))
+	}
+	if value.Excluded {
+		value.Exclude = facetURL(params, removeQueryTerm(query, "-"+term))
+	} else {
+		value.Exclude = facetURL(params, addQueryTerm(removeQueryTerm(query, term), "-"+term))
+	}
+	return value
+}
+
+func hasQueryTerm(query, term string) bool {
+	return findQueryTerm(strings.Fields(query), term) >= 0
+}
+
+// Index of the words of term in words, ignoring case
+func findQueryTerm(words []string, term string) int {
+	size := len(strings.Fields(term))
+	for i := 0; i+size <= len(words); i++ {
+		if strings.EqualFold(strings.Join(words[i:i+size], " "), term) {
+			return i
+		}
+	}
+	return -1
+}
+
+func addQueryTerm(query, term string) string {
+	return strings.TrimSpace(query + " " + term)
+}
+
+func removeQueryTerm(query, term string) string {
+	words := strings.Fields(query)
+	if i := findQueryTerm(words, term); i >= 0 {
+		words = append(words[:i], words[i+len(strings.Fields(term)):]...)
+	}
+	return strings.Join(words, " ")
+}
+
+// This page's parameters with q replaced, back on the first page
+func facetURL(params url.Values, query string) string {
+	values := url.Values{}
+	for key, list := range params {
+		values[key] = list
+	}
+	delete(values, "page")
+	if query == "" {
+		delete(values, "q")
+	} else {
+		values.Set("q", query)
+	}
+	return "/?" + values.Encode()
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func findFacetValue(t *testing.T, facets []Facet, name, label string) FacetValue {
+	t.Helper()
+	for _, facet := range facets {
+		if facet.Name != name {
+			continue
+		}
+		for _, value := range facet.Values {
+			if value.Label == label {
+				return value
+			}
+		}
+	}
+	t.Fatalf("no facet %v: %v in %+v", name, label, facets)
+	return FacetValue{}
+}
+
+func TestBuildFacetsCounts(t *testing.T) {
+	servers := append(queryTestServers(), processServerList([]ServerListItem{
+		{Name: "Empty", Host_address: "127.0.0.1:9", Tags: []string{"pvp", "PvP", "Space Age"}},
+	})...)
+	facets := buildFacets(servers, url.Values{"anypass": {""}})
+
+	if facets[0].Name != "Tags" || facets[0].Values[0].Label != "PvP" || facets[0].Values[0].Count != 4 {
+		t.Fatalf("expected PvP first, counted once per server, got %+v", facets[0])
+	}
+	for _, c := range []struct {
+		name, label string
+		count       int
+	}{
+		{"Mods", "Modded", 1}, {"Mods", "Vanilla", 3},
+		{"Password", "Password", 1}, {"Password", "No password", 3},
+		{"Players", "Populated", 3}, {"Players", "Empty", 1},
+		{"Tags", "space", 1}, {"Tags", "Space Age", 1},
+	} {
+		if got := findFacetValue(t, facets, c.name, c.label).Count; got != c.count {
+			t.Fatalf("%v %v: expected %d, got %d", c.name, c.label, c.count, got)
+		}
+	}
+
+	value := findFacetValue(t, facets, "Tags", "Space Age")
+	link, err := url.Parse(value.Include)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := link.Query().Get("q"); got != `tag="space age"` || !link.Query().Has("anypass") {
+		t.Fatalf("unexpected include link %v", value.Include)
+	}
+}
+
+func TestFacetCountsMatchIncludeLinks(t *testing.T) {
+	//pvp is also part of pvpve, and tags get trimmed
+	servers := append(queryTestServers(), processServerList([]ServerListItem{
+		{Name: "Mixed", Host_address: "127.0.0.1:9", Tags: []string{"pvpve", " Space Age "}},
+		{Name: "Both", Host_address: "127.0.0.1:10", Tags: []string{"PVP", "pvpve"}},
+	})...)
+
+	for _, facet := range buildFacets(servers, url.Values{}) {
+		for _, value := range facet.Values {
+			link, err := url.Parse(value.Include)
+			if err != nil {
+				t.Fatal(err)
+			}
+			query, err := parseQuery(link.Query().Get("q"))
+			if err != nil {
+				t.Fatalf("%v %v: %v", facet.Name, value.Label, err)
+			}
+			matched := 0
+			for _, server := range servers {
+				if query.Match(&server) {
+					matched++
+				}
+			}
+			if matched != value.Count {
+				t.Fatalf("%v %v: counted %d, include shows %d", facet.Name, value.Label, value.Count, matched)
+			}
+		}
+	}
+}
+
+func TestFacetLinksToggle(t *testing.T) {
+	params := url.Values{"q": {"name:space -modded:yes"}, "page": {"3"}, "sort-name": {""}}
+	facets := buildFacets(queryTestServers(), params)
+
+	modded := findFacetValue(t, facets, "Mods", "Modded")
+	if modded.Active || !modded.Excluded {
+		t.Fatalf("expected modded excluded, got %+v", modded)
+	}
+	for link, want := range map[string]string{
+		modded.Include: "name:space modded:yes",
+		modded.Exclude: "name:space",
+	} {
+		parsed, _ := url.Parse(link)
+		if got := parsed.Query().Get("q"); got != want {
+			t.Fatalf("expected q %q, got %q", want, got)
+		}
+		if parsed.Query().Has("page") || !parsed.Query().Has("sort-name") {
+			t.Fatalf("expected page dropped and sort kept, got %v", link)
+		}
+	}
+
+	if !hasQueryTerm("Tag:PvP players>0", "tag:pvp") || hasQueryTerm("tag:pvpve", "tag:pvp") {
+		t.Fatal("expected whole terms to match, ignoring case")
+	}
+	if got := removeQueryTerm(`a tag:"space age" b`, `tag:"space age"`); got != "a b" {
+		t.Fatalf("expected quoted term removed, got %q", got)
+	}
+}
+
+func TestReqHandleShowsFacets(t *testing.T) {
+	setupDurafmt()
+	parseTemplate()
+	restore := configureFetchTestState(t)
+	defer restore()
+	publishSnapshot(newSnapshot(queryTestServers(), time.Now()))
+
+	rec := httptest.NewRecorder()
+	reqHandle(rec, httptest.NewRequest(http.MethodGet, "/?q=players%3E%3D5", nil))
+	body := rec.Body.String()
+	for _, want := range []string{"PvP (2)", "Modded (1)", "Vanilla (1)", `href="/?q=players%3E%3D5&#43;tag%3Dpvp"`} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected %q in the page", want)
+		}
+	}
+}
@@ -1,90 +1,221 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"goFactServView/cwlog"
-	"io"
+	"html/template"
 	"net/http"
 	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-var FetchLock sync.Mutex
+// RefreshLock is held for a whole fetch, so only one runs at a time.
+// Readers never lock, they load the current Snapshot.
+var RefreshLock sync.Mutex
+
+var (
+	//A background refresh is running
+	refreshing atomic.Bool
+	//Unix nanoseconds of our last upstream attempt
+	lastAttempt atomic.Int64
+
+	//Only touched while holding RefreshLock
+	breaker circuitBreaker
+	//Copy of breaker, for readers
+	breakerStatus atomic.Pointer[circuitBreaker]
+)
+
 var fetchHTTPClient = func() *http.Client {
 	return &http.Client{Timeout: ReqTimeout}
 }
 
-func fetchServerList() error {
+// Start one background refresh if the list is stale, never waits
+func triggerRefresh() {
+	if !refreshDue() {
+		return
+	}
+	if !refreshing.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer refreshing.Store(false)
+		RefreshLock.Lock()
+		defer RefreshLock.Unlock()
+		fetchServerList()
+	}()
+}
+
+// Is the list stale, and are we allowed to try now?
+func refreshDue() bool {
+	if offline {
+		return false
+	}
 
 	//Don't refresh unless enough time has passed
-	if time.Since(sParam.LastRefresh) < RefreshInterval {
-		return nil
+	if time.Since(getSnapshot().FetchedAt) < RefreshInterval {
+		return false
 	}
 
 	//Don't attempt if we attempted recently
-	if time.Since(sParam.LastAttempt) < ReqThrottle {
+	return time.Since(time.Unix(0, lastAttempt.Load())) >= ReqThrottle
+}
+
+// Current breaker state, for readers
+func getBreaker() circuitBreaker {
+	if cb := breakerStatus.Load(); cb != nil {
+		return *cb
+	}
+	return circuitBreaker{}
+}
+
+// Publish breaker changes to readers
+func storeBreaker() {
+	cb := breaker
+	breakerStatus.Store(&cb)
+}
+
+// Refresh the list if stale. Callers should hold RefreshLock.
+func fetchServerList() error {
+
+	if !refreshDue() {
 		return nil
 	}
-	sParam.LastAttempt = time.Now().UTC()
+	return refreshServerList()
+}
 
-	//Build query
-	params := url.Values{}
-	params.Add("username", *sParam.Username)
-	params.Add("token", *sParam.Token)
-	urlBuf := buildFetchURL(*sParam.URL, params)
+// Refresh the list now, stale or not. Callers should hold RefreshLock.
+func refreshServerList() error {
+	lastAttempt.Store(time.Now().UnixNano())
+	defer storeBreaker()
 
-	//HTTP GET
-	req, err := http.NewRequest(http.MethodGet, urlBuf, nil)
-	if err != nil {
-		cwlog.DoLog(true, "fetchServerList: request build failed: %v", err)
-		return err
+	//Skip upstream entirely while the circuit is open
+	old := getSnapshot()
+	var err error
+	if breaker.allow() {
+		var snap *Snapshot
+		snap, err = fetchFromSource(serverSource, old)
+		if errors.Is(err, errNotModified) {
+			//Same list as last time, only the fetch time changes
+			breaker.success(serverSource.Name())
+			same := *old
+			same.FetchedAt = time.Now().UTC()
+			publishSnapshot(&same)
+			touchServerCache(same.FetchedAt)
+			recordSamples(&same)
+			cwlog.DoLog(false, "Server list from %v not modified at %v", serverSource.Name(), time.Now())
+			return nil
+		}
+		if errors.Is(err, errHeldBack) {
+			//Upstream is fine, we just don't trust the list yet
+			breaker.success(serverSource.Name())
+			cwlog.DoLog(true, "fetchServerList: %v: %v", serverSource.Name(), err)
+			return err
+		}
+		if err == nil {
+			if old.Mirrored {
+				cwlog.DoLog(true, "fetchServerList: %v recovered, no longer mirroring %v", serverSource.Name(), old.MirrorPeer)
+			}
+			breaker.success(serverSource.Name())
+
+			if cs, ok := serverSource.(conditionalSource); ok {
+				snap.ETag, snap.LastModified = cs.Validators()
+			}
+			acceptSnapshot(snap)
+			cwlog.DoLog(false, "Fetched server list from %v at %v", serverSource.Name(), time.Now())
+			return nil
+		}
+		breaker.failure(serverSource.Name(), err)
+	} else {
+		err = breaker.openErr()
 	}
 
-	req.Header.Set("User-Agent", UserAgent)
+	if len(peerSources) == 0 || breaker.Failures < peerFailover {
+		return err
+	}
 
-	//Get response
-	res, getErr := fetchHTTPClient().Do(req)
-	if getErr != nil {
-		cwlog.DoLog(true, "fetchServerList: request failed: %v", getErr)
-		return getErr
+	//Primary is down, mirror a peer instead
+	snap, peer, peerErr := fetchFromPeers(old)
+	if peerErr != nil {
+		return err
 	}
+	if !old.Mirrored {
+		cwlog.DoLog(true, "fetchServerList: %v failed %v times, mirroring %v", serverSource.Name(), breaker.Failures, peer.Name())
+	}
+
+	snap.Mirrored = true
+	snap.MirrorPeer = peer.Name()
+	acceptSnapshot(snap)
+	return nil
+}
+
+// Publish a new list, save it to the cache and record it in history, stats and presence
+func acceptSnapshot(snap *Snapshot) {
+	publishSnapshot(snap)
+	WriteServerCache(snap)
+	recordHistory(snap)
+	recordSamples(snap)
+}
+
+// Sample player counts, stats and presence, for every fetch, changed or not
+func recordSamples(snap *Snapshot) {
+	recordPlayerCounts(snap.Servers, snap.FetchedAt)
+	recordStats(snap)
+	recordPresence(snap.Servers, snap.FetchedAt)
+}
 
-	//Close once complete, if valid
-	if res.Body != nil {
-		defer res.Body.Close()
+// Fetch, validate and process a list from src, into a new snapshot.
+// old is the current snapshot, to compare against.
+func fetchFromSource(src ServerSource, old *Snapshot) (*Snapshot, error) {
+	rawList, err := src.Fetch()
+	if err != nil {
+		return nil, err
 	}
 
-	//Read all
-	body, readErr := io.ReadAll(res.Body)
-	if readErr != nil {
-		cwlog.DoLog(true, "fetchServerList: read failed: %v", readErr)
-		return readErr
+	//Quarantine bad entries, unless there are too many
+	newServerList, quarantined := quarantineServers(rawList)
+	if err := checkQuarantine(len(rawList), quarantined); err != nil {
+		return nil, err
 	}
+	newServerList = processServerList(newServerList)
 
-	if res.StatusCode != http.StatusOK {
-		err := fmt.Errorf("unexpected upstream status %d: %s", res.StatusCode, shortenBody(body))
-		cwlog.DoLog(true, "fetchServerList: %v", err)
-		return err
+	//Skip if result seems invalid/small
+	if len(newServerList) <= MinValidCount {
+		return nil, fmt.Errorf("upstream returned only %d servers", len(newServerList))
+	}
+	if err := checkListDrop(old.ServersCount, len(newServerList)); err != nil {
+		return nil, err
 	}
 
-	//Unmarshal into temporary list
-	newServerList := []ServerListItem{}
-	jsonErr := json.Unmarshal(body, &newServerList)
-	if jsonErr != nil {
-		cwlog.DoLog(true, "fetchServerList: invalid JSON: %v", jsonErr)
-		return jsonErr
+	snap := newSnapshot(newServerList, time.Now().UTC())
+	snap.Quarantined = len(quarantined)
+	if len(quarantined) > MaxQuarantineSamples {
+		quarantined = quarantined[:MaxQuarantineSamples]
 	}
+	snap.QuarantineSamples = quarantined
+	return snap, nil
+}
 
-	//Remove Factorio tags
+// Clean up a raw server list for web, and sort it
+func processServerList(newServerList []ServerListItem) []ServerListItem {
 	for i, item := range newServerList {
 
+		//Never trust local data from a source, it holds HTML
+		newServerList[i].Local = ServerMetaData{}
 		newServerList[i].Local.ConnectURL = MakeSteamURL(item.Host_address)
+
+		//Rich text for web, plain text for search and sorting
+		newServerList[i].Local.RawName = item.Name
+		newServerList[i].Local.NameHTML = RichTextHTML(item.Name)
+		newServerList[i].Local.DescHTML = RichTextHTML(item.Description)
+		newServerList[i].Local.TagsHTML = make([]template.HTML, len(item.Tags))
 		newServerList[i].Name = RemoveFactorioTags(item.Name)
 		newServerList[i].Description = RemoveFactorioTags(item.Description)
 		for t, tag := range item.Tags {
+			newServerList[i].Local.TagsHTML[t] = RichTextHTML(tag)
 			newServerList[i].Tags[t] = RemoveFactorioTags(tag)
 		}
 
@@ -95,6 +226,7 @@ func fetchServerList() error {
 		//If server name is still nothing, put something into that field.
 		if newServerList[i].Name == "" {
 			newServerList[i].Name = "Unnamed Server"
+			newServerList[i].Local.NameHTML = template.HTML(newServerList[i].Name)
 		}
 		//Convert some of the data for web
 		newServerList[i].Local.Modded = item.Mod_count > 0
@@ -106,36 +238,19 @@ func fetchServerList() error {
 	}
 
 	//Sort list
-	newServerList = sortServers(false, newServerList, SORT_PLAYER)
-
-	//Skip if result seems invalid/small
-	if len(newServerList) <= MinValidCount {
-		err := fmt.Errorf("upstream returned only %d servers", len(newServerList))
-		cwlog.DoLog(true, "fetchServerList: %v", err)
-		return err
-	}
-
-	//Apply temporary list to global list
-	sParam.ServerList.Servers = newServerList
-	sParam.ServersCount = len(sParam.ServerList.Servers)
-	getVersions()
-
-	totalPlayers := 0
-	for _, item := range newServerList {
-		totalPlayers = totalPlayers + len(item.Players)
-	}
-	sParam.PlayerCount = totalPlayers
-	sParam.LastRefresh = time.Now().UTC()
-	WriteServerCache()
-	cwlog.DoLog(false, "Fetched server list at %v", time.Now())
-	return nil
+	return sortServers(false, newServerList, SORT_PLAYER)
 }
 
 func buildFetchURL(baseURL string, params url.Values) string {
+	return buildBaseURL(baseURL) + "/get-games?" + params.Encode()
+}
+
+// Add https:// unless a scheme was given
+func buildBaseURL(baseURL string) string {
 	if strings.HasPrefix(baseURL, "http://") || strings.HasPrefix(baseURL, "https://") {
-		return baseURL + "/get-games?" + params.Encode()
+		return baseURL
 	}
-	return "https://" + baseURL + "/get-games?" + params.Encode()
+	return "https://" + baseURL
 }
 
 func shortenBody(body []byte) string {
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -33,12 +37,8 @@ func TestFetchServerListSuccessUpdatesState(t *testing.T) {
 	restore := configureFetchTestState(t)
 	defer restore()
 
-	*sParam.URL = server.URL
-	sParam.LastRefresh = time.Time{}
-	sParam.LastAttempt = time.Time{}
-	sParam.ServerList.Servers = seedServers(2)
-	sParam.ServersCount = len(sParam.ServerList.Servers)
-	sParam.PlayerCount = 99
+	*upstream.URL = server.URL
+	publishSnapshot(newSnapshot(seedServers(2), time.Time{}))
 	fetchHTTPClient = func() *http.Client {
 		return server.Client()
 	}
@@ -47,19 +47,20 @@ func TestFetchServerListSuccessUpdatesState(t *testing.T) {
 		t.Fatalf("fetchServerList returned error: %v", err)
 	}
 
-	if len(sParam.ServerList.Servers) != MinValidCount+1 {
-		t.Fatalf("expected %d servers, got %d", MinValidCount+1, len(sParam.ServerList.Servers))
+	snap := getSnapshot()
+	if len(snap.Servers) != MinValidCount+1 {
+		t.Fatalf("expected %d servers, got %d", MinValidCount+1, len(snap.Servers))
 	}
-	if sParam.ServersCount != MinValidCount+1 {
-		t.Fatalf("expected ServersCount to be updated, got %d", sParam.ServersCount)
+	if snap.ServersCount != MinValidCount+1 {
+		t.Fatalf("expected ServersCount to be updated, got %d", snap.ServersCount)
 	}
-	if sParam.PlayerCount != MinValidCount+1 {
-		t.Fatalf("expected PlayerCount to be updated, got %d", sParam.PlayerCount)
+	if snap.PlayerCount != MinValidCount+1 {
+		t.Fatalf("expected PlayerCount to be updated, got %d", snap.PlayerCount)
 	}
-	if sParam.LastRefresh.IsZero() {
-		t.Fatal("expected LastRefresh to be updated")
+	if snap.FetchedAt.IsZero() {
+		t.Fatal("expected FetchedAt to be updated")
 	}
-	for _, server := range sParam.ServerList.Servers {
+	for _, server := range snap.Servers {
 		if strings.Contains(server.Tags[0], "[") || strings.Contains(server.Tags[0], "]") {
 			t.Fatalf("expected sanitized tags, got %q", server.Tags[0])
 		}
@@ -72,12 +73,8 @@ func TestFetchServerListNetworkErrorKeepsState(t *testing.T) {
 	restore := configureFetchTestState(t)
 	defer restore()
 
-	original := seedServers(3)
-	sParam.ServerList.Servers = original
-	sParam.ServersCount = len(original)
-	sParam.PlayerCount = 7
-	sParam.LastRefresh = time.Unix(123, 0).UTC()
-	sParam.LastAttempt = time.Time{}
+	original := newSnapshot(seedServers(3), time.Unix(123, 0).UTC())
+	publishSnapshot(original)
 	fetchHTTPClient = func() *http.Client {
 		return &http.Client{
 			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
@@ -90,14 +87,8 @@ func TestFetchServerListNetworkErrorKeepsState(t *testing.T) {
 	if err == nil {
 		t.Fatal("expected error")
 	}
-	if len(sParam.ServerList.Servers) != len(original) {
-		t.Fatalf("expected stale servers to remain, got %d", len(sParam.ServerList.Servers))
-	}
-	if sParam.PlayerCount != 7 {
-		t.Fatalf("expected PlayerCount to remain unchanged, got %d", sParam.PlayerCount)
-	}
-	if !sParam.LastRefresh.Equal(time.Unix(123, 0).UTC()) {
-		t.Fatalf("expected LastRefresh to remain unchanged, got %v", sParam.LastRefresh)
+	if getSnapshot() != original {
+		t.Fatal("expected stale snapshot to remain")
 	}
 }
 
@@ -112,11 +103,9 @@ func TestFetchServerListRejectsNon200(t *testing.T) {
 	restore := configureFetchTestState(t)
 	defer restore()
 
-	*sParam.URL = server.URL
-	sParam.ServerList.Servers = seedServers(4)
-	sParam.ServersCount = 4
-	sParam.PlayerCount = 11
-	sParam.LastRefresh = time.Unix(222, 0).UTC()
+	*upstream.URL = server.URL
+	original := newSnapshot(seedServers(4), time.Unix(222, 0).UTC())
+	publishSnapshot(original)
 	fetchHTTPClient = func() *http.Client {
 		return server.Client()
 	}
@@ -128,11 +117,8 @@ func TestFetchServerListRejectsNon200(t *testing.T) {
 	if !strings.Contains(err.Error(), "unexpected upstream status 429") {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if len(sParam.ServerList.Servers) != 4 {
-		t.Fatalf("expected stale servers to remain, got %d", len(sParam.ServerList.Servers))
-	}
-	if !sParam.LastRefresh.Equal(time.Unix(222, 0).UTC()) {
-		t.Fatalf("expected LastRefresh to remain unchanged, got %v", sParam.LastRefresh)
+	if getSnapshot() != original {
+		t.Fatal("expected stale snapshot to remain")
 	}
 }
 
@@ -147,11 +133,9 @@ func TestFetchServerListRejectsInvalidJSON(t *testing.T) {
 	restore := configureFetchTestState(t)
 	defer restore()
 
-	*sParam.URL = server.URL
-	sParam.ServerList.Servers = seedServers(5)
-	sParam.ServersCount = 5
-	sParam.PlayerCount = 12
-	sParam.LastRefresh = time.Unix(333, 0).UTC()
+	*upstream.URL = server.URL
+	original := newSnapshot(seedServers(5), time.Unix(333, 0).UTC())
+	publishSnapshot(original)
 	fetchHTTPClient = func() *http.Client {
 		return server.Client()
 	}
@@ -160,11 +144,8 @@ func TestFetchServerListRejectsInvalidJSON(t *testing.T) {
 	if err == nil {
 		t.Fatal("expected error")
 	}
-	if len(sParam.ServerList.Servers) != 5 {
-		t.Fatalf("expected stale servers to remain, got %d", len(sParam.ServerList.Servers))
-	}
-	if !sParam.LastRefresh.Equal(time.Unix(333, 0).UTC()) {
-		t.Fatalf("expected LastRefresh to remain unchanged, got %v", sParam.LastRefresh)
+	if getSnapshot() != original {
+		t.Fatal("expected stale snapshot to remain")
 	}
 }
 
@@ -179,11 +160,9 @@ func TestFetchServerListRejectsUndersizedPayload(t *testing.T) {
 	restore := configureFetchTestState(t)
 	defer restore()
 
-	*sParam.URL = server.URL
-	sParam.ServerList.Servers = seedServers(6)
-	sParam.ServersCount = 6
-	sParam.PlayerCount = 13
-	sParam.LastRefresh = time.Unix(444, 0).UTC()
+	*upstream.URL = server.URL
+	original := newSnapshot(seedServers(6), time.Unix(444, 0).UTC())
+	publishSnapshot(original)
 	fetchHTTPClient = func() *http.Client {
 		return server.Client()
 	}
@@ -195,43 +174,260 @@ func TestFetchServerListRejectsUndersizedPayload(t *testing.T) {
 	if !strings.Contains(err.Error(), "returned only") {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if len(sParam.ServerList.Servers) != 6 {
-		t.Fatalf("expected stale servers to remain, got %d", len(sParam.ServerList.Servers))
+	if getSnapshot() != original {
+		t.Fatal("expected stale snapshot to remain")
 	}
-	if !sParam.LastRefresh.Equal(time.Unix(444, 0).UTC()) {
-		t.Fatalf("expected LastRefresh to remain unchanged, got %v", sParam.LastRefresh)
+}
+
+func TestFetchServerListGzipAndNotModified(t *testing.T) {
+	setupDurafmt()
+
+	hits := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		if got := r.Header.Get("Accept-Encoding"); got != "gzip" {
+			t.Fatalf("unexpected Accept-Encoding: %q", got)
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(w)
+		fmt.Fprint(zw, makeServerListJSON(MinValidCount+1))
+		zw.Close()
+	}))
+	defer server.Close()
+
+	restore := configureFetchTestState(t)
+	defer restore()
+
+	*upstream.URL = server.URL
+	fetchHTTPClient = func() *http.Client {
+		return server.Client()
+	}
+
+	if err := fetchServerList(); err != nil {
+		t.Fatalf("fetchServerList returned error: %v", err)
+	}
+	snap := getSnapshot()
+	if snap.ServersCount != MinValidCount+1 {
+		t.Fatalf("expected %d servers, got %d", MinValidCount+1, snap.ServersCount)
+	}
+	if snap.ETag != `"v1"` || snap.LastModified == "" {
+		t.Fatalf("expected validators to be stored, got %q %q", snap.ETag, snap.LastModified)
+	}
+
+	//304: counts as a refresh, list untouched
+	firstServer := &snap.Servers[0]
+	markStale()
+	if err := fetchServerList(); err != nil {
+		t.Fatalf("fetchServerList returned error on 304: %v", err)
+	}
+	if hits != 2 {
+		t.Fatalf("expected 2 upstream requests, got %d", hits)
+	}
+	snap = getSnapshot()
+	if snap.FetchedAt.IsZero() {
+		t.Fatal("expected FetchedAt to be updated on 304")
+	}
+	if &snap.Servers[0] != firstServer || snap.ServersCount != MinValidCount+1 {
+		t.Fatal("expected server list to be left alone on 304")
 	}
 }
 
+func TestFetchServerListNotModifiedWithGzipHeader(t *testing.T) {
+	setupDurafmt()
+
+	restore := configureFetchTestState(t)
+	defer restore()
+
+	original := newSnapshot(seedServers(4), time.Unix(555, 0).UTC())
+	publishSnapshot(original)
+
+	//A 304 has no body, even if it says it's gzipped
+	fetchHTTPClient = func() *http.Client {
+		return &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				header := http.Header{}
+				header.Set("Content-Encoding", "gzip")
+				return &http.Response{
+					StatusCode: http.StatusNotModified,
+					Header:     header,
+					Body:       http.NoBody,
+					Request:    req,
+				}, nil
+			}),
+		}
+	}
+
+	if err := fetchServerList(); err != nil {
+		t.Fatalf("fetchServerList returned error on gzip 304: %v", err)
+	}
+	snap := getSnapshot()
+	if &snap.Servers[0] != &original.Servers[0] || snap.ServersCount != original.ServersCount {
+		t.Fatal("expected server list to be left alone on 304")
+	}
+}
+
+func TestFetchServerListNotModifiedRecordsSamples(t *testing.T) {
+	setupDurafmt()
+
+	restore := configureFetchTestState(t)
+	defer restore()
+	restoreCache := configureCacheTestState(t)
+	defer restoreCache()
+	restoreHistory := configureHistoryTestState(t)
+	defer restoreHistory()
+	restorePresence := configurePresenceTestState(t)
+	defer restorePresence()
+	PlayerCountLock.Lock()
+	oldCounts := playerCounts
+	playerCounts = map[string][]playerSample{}
+	PlayerCountLock.Unlock()
+	defer func() { playerCounts = oldCounts }()
+
+	servers := processServerList(seedServers(MinValidCount + 1))
+	servers[0].Players = []string{"alice"}
+	start := time.Now().UTC().Add(-MaxSessionGap / 2)
+	publishSnapshot(newSnapshot(servers, start))
+	recordPresence(servers, start)
+	serverSource = &stubSource{err: errNotModified}
+
+	//Unchanged lists still count, so sparklines don't get gaps
+	for i := 0; i < 2; i++ {
+		markStale()
+		if err := fetchServerList(); err != nil {
+			t.Fatalf("fetchServerList returned error: %v", err)
+		}
+	}
+	if got := len(serverPlayerCounts(servers[0])); got != 2 {
+		t.Fatalf("expected 2 player count samples, got %d", got)
+	}
+	if len(statsPoints) != 2 {
+		t.Fatalf("expected 2 stats points, got %d", len(statsPoints))
+	}
+	//One session, kept going by each unchanged list
+	sessions := presence["alice"].Sessions
+	if len(sessions) != 1 || !sessions[0].Start.Equal(start) || !sessions[0].End.Equal(getSnapshot().FetchedAt) {
+		t.Fatalf("expected one session up to the last fetch, got %+v", sessions)
+	}
+}
+
+func TestTriggerRefreshRunsOnceInBackground(t *testing.T) {
+	setupDurafmt()
+
+	restore := configureFetchTestState(t)
+	defer restore()
+
+	release := make(chan struct{})
+	var calls atomic.Int32
+	serverSource = &blockingSource{
+		list:    []byte(makeServerListJSON(MinValidCount + 1)),
+		release: release,
+		calls:   &calls,
+	}
+
+	//Many stale requests, none of them wait
+	for i := 0; i < 10; i++ {
+		triggerRefresh()
+	}
+	if !refreshing.Load() {
+		t.Fatal("expected a refresh to be running")
+	}
+	close(release)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for refreshing.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("refresh never finished")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("expected one upstream fetch, got %d", calls.Load())
+	}
+
+	if getSnapshot().ServersCount != MinValidCount+1 {
+		t.Fatalf("expected refreshed list, got %d servers", getSnapshot().ServersCount)
+	}
+}
+
+type blockingSource struct {
+	list    []byte
+	release chan struct{}
+	calls   *atomic.Int32
+}
+
+func (src *blockingSource) Name() string {
+	return "blocking"
+}
+
+func (src *blockingSource) Fetch() ([]ServerListItem, error) {
+	src.calls.Add(1)
+	<-src.release
+	return decodeServerList(bytes.NewReader(src.list))
+}
+
 func configureFetchTestState(t *testing.T) func() {
 	t.Helper()
 
-	oldState := sParam
+	oldUpstream := upstream
+	oldSnapshot := currentSnapshot.Load()
+	oldBreaker := breaker
+	oldAttempt := lastAttempt.Load()
 	oldClient := fetchHTTPClient
+	oldSource := serverSource
+	oldCacheFile := CacheFile
 
 	username := "user"
 	token := "token"
 	baseURL := "https://example.invalid"
-	sParam = ServerStateData{
-		URL:       &baseURL,
-		Username:  &username,
-		Token:     &token,
-		UserAgent: UserAgent,
+	upstream = UpstreamConfig{
+		URL:      &baseURL,
+		Username: &username,
+		Token:    &token,
 	}
+	currentSnapshot.Store(nil)
+	breaker = circuitBreaker{}
+	breakerStatus.Store(nil)
+	lastAttempt.Store(0)
+	heldBack = 0
+	serverSource = &httpSource{}
+	//Never touch the real cache
+	CacheFile = filepath.Join(t.TempDir(), "cache.json")
 
 	return func() {
-		sParam = oldState
+		upstream = oldUpstream
+		currentSnapshot.Store(oldSnapshot)
+		breaker = oldBreaker
+		breakerStatus.Store(nil)
+		lastAttempt.Store(oldAttempt)
 		fetchHTTPClient = oldClient
+		serverSource = oldSource
+		CacheFile = oldCacheFile
 	}
 }
 
+// Let the next fetchServerList go ahead
+func markStale() {
+	snap := *getSnapshot()
+	snap.FetchedAt = time.Time{}
+	publishSnapshot(&snap)
+	lastAttempt.Store(0)
+}
+
 func seedServers(count int) []ServerListItem {
 	servers := make([]ServerListItem, 0, count)
 	for i := 1; i <= count; i++ {
 		servers = append(servers, ServerListItem{
-			Name:    fmt.Sprintf("seed-%d", i),
-			Players: []string{"seed-player"},
-			Tags:    []string{"seed-tag"},
+			Name:                fmt.Sprintf("seed-%d", i),
+			Host_address:        fmt.Sprintf("127.0.0.1:%d", 4000+i),
+			Application_version: appVersionData{Game_version: "2.0.1"},
+			Players:             []string{"seed-player"},
+			Tags:                []string{"seed-tag"},
 		})
 	}
 	return servers
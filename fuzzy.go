@@ -0,0 +1,132 @@
+package main
+
+import (
+	"html"
+	"html/template"
+	"unicode"
+)
+
+// Most edits a fuzzy search can ask for
+const MaxFuzzyTolerance = 4
+
+// Edits allowed in a fuzzy search, unless the request asks for fewer or more
+var FuzzyTolerance = 2
+
+// Where a fuzzy search matched, Start and End are rune offsets
+type fuzzyMatch struct {
+	Dist, Start, End int
+}
+
+// Letters people swap for each other in names, compared as one
+var fuzzyFoldTable = map[rune]rune{
+	'0': 'o', '1': 'i', 'l': 'i', '|': 'i', '3': 'e', '4': 'a', '@': 'a',
+	'5': 's', '$': 's', '7': 't', ' ': '_', '-': '_', '.': '_',
+}
+
+func fuzzyFold(text string) []rune {
+	runes := []rune(text)
+	for i, r := range runes {
+		r = unicode.ToLower(r)
+		if plain, found := searchFoldTable[r]; found && len(plain) == 1 {
+			r = rune(plain[0])
+		}
+		if same, found := fuzzyFoldTable[r]; found {
+			r = same
+		}
+		runes[i] = r
+	}
+	return runes
+}
+
+// Edits allowed for a pattern, short patterns get fewer so they don't match everything
+func fuzzyLimit(pattern string, tolerance int) int {
+	return max(0, min(tolerance, (len([]rune(pattern))-1)/2))
+}
+
+// Best approximate match of pattern anywhere in text, within tolerance edits
+func fuzzyFind(text, pattern string, tolerance int) (fuzzyMatch, bool) {
+	p, t := fuzzyFold(pattern), fuzzyFold(text)
+	if len(p) == 0 {
+		return fuzzyMatch{}, false
+	}
+	limit := fuzzyLimit(pattern, tolerance)
+
+	//Edit distance of pattern so far against text ending at j,
+	//the match can start anywhere for free
+	prev, cur := make([]int, len(t)+1), make([]int, len(t)+1)
+	prevStart, curStart := make([]int, len(t)+1), make([]int, len(t)+1)
+	for j := range prev {
+		prevStart[j] = j
+	}
+	for i := 1; i <= len(p); i++ {
+		cur[0], curStart[0] = i, 0
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if p[i-1] == t[j-1] {
+				cost = 0
+			}
+			cur[j], curStart[j] = prev[j-1]+cost, prevStart[j-1]
+			if prev[j]+1 < cur[j] {
+				cur[j], curStart[j] = prev[j]+1, prevStart[j]
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j], curStart[j] = cur[j-1]+1, curStart[j-1]
+			}
+		}
+		prev, cur = cur, prev
+		prevStart, curStart = curStart, prevStart
+	}
+
+	best := fuzzyMatch{Dist: limit + 1}
+	for j := 1; j <= len(t); j++ {
+		if prev[j] < best.Dist {
+			best = fuzzyMatch{Dist: prev[j], Start: prevStart[j], End: j}
+		}
+	}
+	return best, best.Dist <= limit
+}
+
+// Text with the matched part marked
+func fuzzyMarkHTML(text string, match fuzzyMatch) template.HTML {
+	runes := []rune(text)
+	return template.HTML(html.EscapeString(string(runes[:match.Start])) +
+		"<mark>" + html.EscapeString(string(runes[match.Start:match.End])) + "</mark>" +
+		html.EscapeString(string(runes[match.End:])))
+}
+
+// Is this request a fuzzy name or player search
+func fuzzySearch(tempParams *ServerStateData) bool {
+	return tempParams.Fuzzy && tempParams.Searched != "" && (tempParams.FName || tempParams.FPlayer)
+}
+
+// Fuzzy name or player search for one server, marking what matched
+func fuzzyServer(server *ServerListItem, tempParams *ServerStateData) bool {
+	if tempParams.FName {
+		//Mark the name as sent, so it keeps its colors
+		name := server.Local.RawName
+		if name == "" {
+			name = server.Name
+		}
+		match, found := fuzzyFind(richPlainText(name), tempParams.Searched, tempParams.FuzzyTolerance)
+		if !found {
+			return false
+		}
+		server.Local.NameHTML = RichTextHTMLMark(name, match.Start, match.End)
+		server.Local.Relevance = tempParams.FuzzyTolerance + 1 - match.Dist
+		return true
+	}
+
+	best, bestPlayer := fuzzyMatch{}, ""
+	for _, player := range server.Players {
+		match, found := fuzzyFind(player, tempParams.Searched, tempParams.FuzzyTolerance)
+		if found && (bestPlayer == "" || match.Dist < best.Dist) {
+			best, bestPlayer = match, player
+		}
+	}
+	if bestPlayer == "" {
+		return false
+	}
+	server.Local.MatchHTML = "Player: " + fuzzyMarkHTML(bestPlayer, best)
+	server.Local.Relevance = tempParams.FuzzyTolerance + 1 - best.Dist
+	return true
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFuzzyFind(t *testing.T) {
+	cases := []struct {
+		text, pattern string
+		dist          int
+		found         bool
+		marked        string
+	}{
+		{"xX_Bob_Xx", "bob_x", 0, true, "Bob_X"},
+		{"xX_Bob_Xx", "bob x", 0, true, "Bob_X"},
+		{"B0bTheBuilder", "bob", 0, true, "B0b"},
+		{"xX_Bob_Xx", "bbo_x", 2, true, ""},
+		{"SpaceCadet", "spcae", 2, true, ""},
+		{"Alice", "bob", 0, false, ""},
+		//Short patterns get no edits
+		{"Alice", "ax", 0, false, ""},
+	}
+	for _, c := range cases {
+		match, found := fuzzyFind(c.text, c.pattern, 2)
+		if found != c.found {
+			t.Fatalf("fuzzyFind(%q, %q): expected found %v, got %+v", c.text, c.pattern, c.found, match)
+		}
+		if !found {
+			continue
+		}
+		if match.Dist != c.dist {
+			t.Fatalf("fuzzyFind(%q, %q): expected distance %d, got %d", c.text, c.pattern, c.dist, match.Dist)
+		}
+		if c.marked != "" && string([]rune(c.text)[match.Start:match.End]) != c.marked {
+			t.Fatalf("fuzzyFind(%q, %q): expected %q matched, got %+v", c.text, c.pattern, c.marked, match)
+		}
+	}
+
+	if _, found := fuzzyFind("xX_Bob_Xx", "bbo_x", 1); found {
+		t.Fatal("expected a lower tolerance to miss")
+	}
+}
+
+func TestFilterServersFuzzyPlayer(t *testing.T) {
+	servers := processServerList([]ServerListItem{
+		{Name: "Typo", Host_address: "127.0.0.1:1", Players: []string{"someone", "bbo_x1"}},
+		{Name: "Exact", Host_address: "127.0.0.1:2", Players: []string{"xX_Bob_Xx"}},
+		{Name: "Nobody", Host_address: "127.0.0.1:3", Players: []string{"alice"}},
+	})
+	params := &ServerStateData{Servers: servers, Searched: "bob_x", FPlayer: true, Fuzzy: true, FuzzyTolerance: 2}
+	filterServers(params)
+	list := sortServers(false, params.Servers, SORT_RELEVANCE)
+
+	if len(list) != 2 || list[0].Name != "Exact" || list[1].Name != "Typo" {
+		t.Fatalf("expected Exact then Typo, got %+v", list)
+	}
+	if got := string(list[0].Local.MatchHTML); got != "Player: xX_<mark>Bob_X</mark>x" {
+		t.Fatalf("unexpected match highlight %q", got)
+	}
+
+	//Names are marked in the rich text, keeping their colors
+	params = &ServerStateData{Servers: processServerList([]ServerListItem{
+		{Name: "[color=red]Spaxe[/color] Age", Host_address: "127.0.0.1:1"},
+	}), Searched: "space", FName: true, Fuzzy: true, FuzzyTolerance: 2}
+	filterServers(params)
+	if params.ServersCount != 1 || !strings.Contains(string(params.Servers[0].Local.NameHTML), `<span style="color:#ff0000"><mark>Spaxe</mark></span>`) {
+		t.Fatalf("expected marked name, got %+v", params.Servers)
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goFactServView/cwlog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	//Format of files in the history store
+	HistoryVersion = 1
+	//How often old history is thinned out
+	HistoryCompactInterval = time.Hour
+	//History file names, in UTC
+	historyTimeFormat = "20060102T150405Z"
+	historyExt        = ".json.gz"
+
+	DefaultRetention = "7d:full,90d:1h,forever:1d"
+)
+
+var (
+	historyDir = "data/history"
+	//Empty means history is off
+	historyRetention []retentionRule
+
+	//Protects historyIndex and the files in historyDir
+	HistoryLock sync.Mutex
+	//Times of stored snapshots, oldest first
+	historyIndex []time.Time
+
+	errNoHistory = errors.New("no history")
+)
+
+// One stored snapshot. Local data is left out, it can be rebuilt.
+type HistorySnapshot struct {
+	Version   int
+	FetchedAt time.Time
+	Mirrored  bool `json:",omitempty"`
+	Servers   []ServerListItem
+}
+
+// Snapshots younger than MaxAge keep one per Resolution.
+// A zero Resolution keeps everything, a zero MaxAge means forever.
+type retentionRule struct {
+	MaxAge     time.Duration
+	Resolution time.Duration
+}
+
+// Parse "7d:full,90d:1h,forever:1d" into rules, youngest first
+func parseRetention(spec string) ([]retentionRule, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" || spec == "off" {
+		return nil, nil
+	}
+
+	var rules []retentionRule
+	for _, part := range strings.Split(spec, ",") {
+		age, res, found := strings.Cut(strings.TrimSpace(part), ":")
+		if !found {
+			return nil, fmt.Errorf("retention rule %q: expected age:resolution", part)
+		}
+
+		rule := retentionRule{}
+		if age != "forever" {
+			d, err := parseDays(age)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("retention rule %q: bad age %q", part, age)
+			}
+			rule.MaxAge = d
+		}
+		if res != "full" {
+			d, err := parseDays(res)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("retention rule %q: bad resolution %q", part, res)
+			}
+			rule.Resolution = d
+		}
+
+		if len(rules) > 0 {
+			last := rules[len(rules)-1]
+			if last.MaxAge == 0 {
+				return nil, fmt.Errorf("retention rule %q comes after forever", part)
+			}
+			if rule.MaxAge != 0 && rule.MaxAge <= last.MaxAge {
+				return nil, fmt.Errorf("retention rule %q: ages must increase", part)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// Like time.ParseDuration, but also takes whole days: "7d"
+func parseDays(value string) (time.Duration, error) {
+	if days, found := strings.CutSuffix(value, "d"); found {
+		num, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(num) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(value)
+}
+
+func historyPath(when time.Time) string {
+	return filepath.Join(historyDir, when.UTC().Format(historyTimeFormat)+historyExt)
+}
+
+// Read the times of stored snapshots from disk
+func loadHistoryIndex() error {
+	HistoryLock.Lock()
+	defer HistoryLock.Unlock()
+
+	entries, err := os.ReadDir(historyDir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	historyIndex = historyIndex[:0]
+	for _, entry := range entries {
+		name, found := strings.CutSuffix(entry.Name(), historyExt)
+		if !found || entry.IsDir() {
+			continue
+		}
+		when, err := time.Parse(historyTimeFormat, name)
+		if err != nil {
+			continue
+		}
+		historyIndex = append(historyIndex, when)
+	}
+	sort.Slice(historyIndex, func(i, j int) bool { return historyIndex[i].Before(historyIndex[j]) })
+	return nil
+}
+
+// Add an accepted snapshot to the store. Existing files are never rewritten.
+func recordHistory(snap *Snapshot) {
+	if len(historyRetention) == 0 {
+		return
+	}
+
+	servers := make([]ServerListItem, len(snap.Servers))
+	for i, item := range snap.Servers {
+		item.Local = ServerMetaData{}
+		servers[i] = item
+	}
+	entry := HistorySnapshot{
+		Version:   HistoryVersion,
+		FetchedAt: snap.FetchedAt.UTC().Truncate(time.Second),
+		Mirrored:  snap.Mirrored,
+		Servers:   servers,
+	}
+
+	HistoryLock.Lock()
+	defer HistoryLock.Unlock()
+
+	if err := writeHistory(entry); err != nil {
+		cwlog.DoLog(true, "recordHistory: %v", err)
+		return
+	}
+	historyIndex = append(historyIndex, entry.FetchedAt)
+}
+
+func writeHistory(entry HistorySnapshot) error {
+	path := historyPath(entry.FetchedAt)
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%v already exists", path)
+	}
+	if err := os.MkdirAll(historyDir, 0755); err != nil {
+		return err
+	}
+
+	tempPath := path + ".tmp"
+	file, err := os.Create(tempPath)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(file)
+	err = json.NewEncoder(gz).Encode(entry)
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return os.Rename(tempPath, path)
+}
+
+func readHistory(when time.Time) (*HistorySnapshot, error) {
+	file, err := os.Open(historyPath(when))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	entry := &HistorySnapshot{}
+	if err := json.NewDecoder(gz).Decode(entry); err != nil {
+		return nil, err
+	}
+	if entry.Version != HistoryVersion {
+		return nil, fmt.Errorf("history version %d not supported", entry.Version)
+	}
+	return entry, nil
+}
+
+// The stored snapshot taken at exactly when
+func historyAt(when time.Time) (*HistorySnapshot, error) {
+	HistoryLock.Lock()
+	defer HistoryLock.Unlock()
+
+	return readHistory(when)
+}
+
+// The stored snapshot nearest to when
+func historyClosest(when time.Time) (*HistorySnapshot, error) {
+	HistoryLock.Lock()
+	defer HistoryLock.Unlock()
+
+	if len(historyIndex) == 0 {
+		return nil, errNoHistory
+	}
+
+	pos := sort.Search(len(historyIndex), func(i int) bool { return !historyIndex[i].Before(when) })
+	best := pos
+	if pos == len(historyIndex) || (pos > 0 && when.Sub(historyIndex[pos-1]) <= historyIndex[pos].Sub(when)) {
+		best = pos - 1
+	}
+	return readHistory(historyIndex[best])
+}
+
+// Times of stored snapshots, oldest first
+func historyTimes() []time.Time {
+	HistoryLock.Lock()
+	defer HistoryLock.Unlock()
+
+	return append([]time.Time(nil), historyIndex...)
+}
+
+// Which stored snapshots the retention policy keeps, as of now
+func retainedHistory(rules []retentionRule, times []time.Time, now time.Time) []bool {
+	type bucket struct {
+		rule int
+		slot int64
+	}
+	keep := make([]bool, len(times))
+	seen := map[bucket]bool{}
+
+	//Oldest first, so each bucket keeps its earliest snapshot
+	for i, when := range times {
+		age := now.Sub(when)
+		for r, rule := range rules {
+			if rule.MaxAge != 0 && age > rule.MaxAge {
+				continue
+			}
+			if rule.Resolution == 0 {
+				keep[i] = true
+				break
+			}
+			key := bucket{rule: r, slot: when.UnixNano() / int64(rule.Resolution)}
+			if !seen[key] {
+				seen[key] = true
+				keep[i] = true
+			}
+			break
+		}
+	}
+	return keep
+}
+
+// Delete snapshots the retention policy no longer keeps
+func compactHistory(now time.Time) (int, error) {
+	HistoryLock.Lock()
+	defer HistoryLock.Unlock()
+
+	keep := retainedHistory(historyRetention, historyIndex, now)
+	kept := historyIndex[:0]
+	removed := 0
+	var firstErr error
+
+	for i, when := range historyIndex {
+		if keep[i] {
+			kept = append(kept, when)
+			continue
+		}
+		if err := os.Remove(historyPath(when)); err != nil && !os.IsNotExist(err) {
+			if firstErr == nil {
+				firstErr = err
+			}
+			kept = append(kept, when)
+			continue
+		}
+		removed++
+	}
+	historyIndex = kept
+	return removed, firstErr
+}
+
+// In background, thin out old history and stats, and save player sessions
+func backgroundCompactHistory() {
+	for {
+		removed, err := compactHistory(time.Now().UTC())
+		if err != nil {
+			cwlog.DoLog(true, "compactHistory: %v", err)
+		}
+		if removed > 0 {
+			cwlog.DoLog(false, "Compacted history, removed %v snapshots.", removed)
+		}
+		if _, err := compactStats(time.Now().UTC()); err != nil {
+			cwlog.DoLog(true, "compactStats: %v", err)
+		}
+		if err := savePresence(); err != nil {
+			cwlog.DoLog(true, "savePresence: %v", err)
+		}
+		time.Sleep(HistoryCompactInterval)
+	}
+}
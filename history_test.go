@@ -0,0 +1,143 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func configureHistoryTestState(t *testing.T) func() {
+	t.Helper()
+
+	oldDir, oldRetention, oldIndex := historyDir, historyRetention, historyIndex
+	oldStatsFile, oldStats := statsFile, statsPoints
+	historyDir = t.TempDir()
+	historyRetention, _ = parseRetention(DefaultRetention)
+	historyIndex = nil
+	statsFile = filepath.Join(historyDir, "stats.jsonl")
+	statsPoints = nil
+
+	return func() {
+		historyDir, historyRetention, historyIndex = oldDir, oldRetention, oldIndex
+		statsFile, statsPoints = oldStatsFile, oldStats
+	}
+}
+
+func TestParseRetention(t *testing.T) {
+	rules, err := parseRetention(DefaultRetention)
+	if err != nil {
+		t.Fatalf("parseRetention returned error: %v", err)
+	}
+	want := []retentionRule{
+		{MaxAge: 7 * 24 * time.Hour},
+		{MaxAge: 90 * 24 * time.Hour, Resolution: time.Hour},
+		{Resolution: 24 * time.Hour},
+	}
+	if len(rules) != len(want) {
+		t.Fatalf("expected %d rules, got %d", len(want), len(rules))
+	}
+	for i := range want {
+		if rules[i] != want[i] {
+			t.Fatalf("rule %d: expected %+v, got %+v", i, want[i], rules[i])
+		}
+	}
+
+	if rules, err := parseRetention("off"); err != nil || rules != nil {
+		t.Fatalf("expected off to disable history, got %v, %v", rules, err)
+	}
+	for _, bad := range []string{"7d", "90d:1h,7d:full", "forever:1d,7d:full", "7x:full", "7d:0s"} {
+		if _, err := parseRetention(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestRetainedHistoryThinsOlderTiers(t *testing.T) {
+	rules, _ := parseRetention("7d:full,90d:1h")
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	times := []time.Time{
+		//Older than any rule
+		now.Add(-100 * 24 * time.Hour),
+		//Same hour, 10 days ago, only the first stays
+		now.Add(-10*24*time.Hour - 50*time.Minute),
+		now.Add(-10*24*time.Hour - 45*time.Minute),
+		now.Add(-10*24*time.Hour - 40*time.Minute),
+		//Recent, all stay
+		now.Add(-10 * time.Minute),
+		now.Add(-5 * time.Minute),
+	}
+	want := []bool{false, true, false, false, true, true}
+
+	keep := retainedHistory(rules, times, now)
+	for i := range want {
+		if keep[i] != want[i] {
+			t.Fatalf("snapshot %d: expected keep=%v, got %v", i, want[i], keep[i])
+		}
+	}
+}
+
+func TestHistoryRecordAndClosest(t *testing.T) {
+	restore := configureHistoryTestState(t)
+	defer restore()
+
+	base := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		snap := newSnapshot(seedServers(MinValidCount+1+i), base.Add(time.Duration(i)*time.Hour))
+		recordHistory(snap)
+	}
+
+	//Start fresh, from what's on disk
+	historyIndex = nil
+	if err := loadHistoryIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if len(historyTimes()) != 3 {
+		t.Fatalf("expected 3 stored snapshots, got %d", len(historyTimes()))
+	}
+
+	entry, err := historyClosest(base.Add(80 * time.Minute))
+	if err != nil {
+		t.Fatalf("historyClosest returned error: %v", err)
+	}
+	if !entry.FetchedAt.Equal(base.Add(time.Hour)) {
+		t.Fatalf("expected snapshot at %v, got %v", base.Add(time.Hour), entry.FetchedAt)
+	}
+	if len(entry.Servers) != MinValidCount+2 {
+		t.Fatalf("expected %d servers, got %d", MinValidCount+2, len(entry.Servers))
+	}
+	if entry.Servers[0].Local.ConnectURL != "" {
+		t.Fatal("expected local data to be left out of history")
+	}
+
+	entry, err = historyClosest(base.Add(-time.Hour))
+	if err != nil || !entry.FetchedAt.Equal(base) {
+		t.Fatalf("expected oldest snapshot, got %v, %v", entry, err)
+	}
+}
+
+func TestCompactHistoryRemovesFiles(t *testing.T) {
+	restore := configureHistoryTestState(t)
+	defer restore()
+
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	old := now.Add(-30*24*time.Hour - 30*time.Minute)
+	recordHistory(newSnapshot(seedServers(MinValidCount+1), old))
+	recordHistory(newSnapshot(seedServers(MinValidCount+1), old.Add(5*time.Minute)))
+	recordHistory(newSnapshot(seedServers(MinValidCount+1), now))
+
+	removed, err := compactHistory(now)
+	if err != nil {
+		t.Fatalf("compactHistory returned error: %v", err)
+	}
+	if removed != 1 {
+		t.Fatalf("expected 1 snapshot removed, got %d", removed)
+	}
+	if _, err := os.Stat(historyPath(old.Add(5 * time.Minute))); !os.IsNotExist(err) {
+		t.Fatal("expected compacted snapshot file to be deleted")
+	}
+	if len(historyTimes()) != 2 {
+		t.Fatalf("expected 2 snapshots left, got %d", len(historyTimes()))
+	}
+}
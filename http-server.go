@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -14,6 +15,7 @@ const (
 	SORT_DESC
 	SORT_TIME
 	SORT_RTIME
+	SORT_RELEVANCE
 )
 
 // HTTP request handler
@@ -22,34 +24,60 @@ func reqHandle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	//Our snapshot, for peers
+	if r.URL.Path == "/snapshot.json" {
+		snapshotHandle(w, r)
+		return
+	}
+
+	//Statistics page
+	if r.URL.Path == "/stats" {
+		statsHandle(w, r)
+		return
+	}
+
+	//Player presence page
+	if r.URL.Path == "/player" || strings.HasPrefix(r.URL.Path, "/player/") {
+		playerHandle(w, r)
+		return
+	}
+
+	//Server details page
+	if strings.HasPrefix(r.URL.Path, "/server/") {
+		serverDetailHandle(w, r)
+		return
+	}
+
 	//If this isn't a query, pass to file server
 	if !strings.EqualFold(r.RequestURI, "/") && !strings.HasPrefix(r.RequestURI, "/?") {
 		fileServer.ServeHTTP(w, r)
 		return
 	}
 
-	FetchLock.Lock()
-
-	//If needed, refresh data
-	fetchServerList()
+	//If needed, refresh data in the background
+	triggerRefresh()
 
 	//Build temporary server params
+	snap := getSnapshot()
 	var tempParams *ServerStateData = &ServerStateData{
-		URL:          sParam.URL,
-		Query:        sParam.Query,
-		Token:        sParam.Token,
-		Username:     sParam.Username,
-		LastRefresh:  sParam.LastRefresh,
-		LastAttempt:  sParam.LastAttempt,
-		UserAgent:    sParam.UserAgent,
-		ServerList:   sParam.ServerList,
-		ItemsPerPage: ItemsPerPage,
-		VersionList:  sParam.VersionList,
-		PlayerCount:  sParam.PlayerCount,
+		Servers:       snap.Servers,
+		VersionList:   snap.VersionList,
+		VersionGroups: snap.VersionGroups,
+		LastRefresh:   snap.FetchedAt,
+		Mirrored:      snap.Mirrored,
+		MirrorPeer:    snap.MirrorPeer,
+		PlayerCount:   snap.PlayerCount,
+		Quarantined:   snap.Quarantined,
+		Breaker:       getBreaker(),
+		ItemsPerPage:  ItemsPerPage,
+		Refreshing:    refreshing.Load(),
+		Offline:       offline,
+		DataAge:       updateTime(int(time.Since(snap.FetchedAt).Minutes())),
+		index:         snap.Index,
+
+		FuzzyTolerance: FuzzyTolerance,
 	}
 
-	FetchLock.Unlock()
-
 	//Create a blank server list
 	page := 1
 
@@ -60,6 +88,7 @@ func reqHandle(w http.ResponseWriter, r *http.Request) {
 	//parseTemplate()
 
 	sortBy := SORT_PLAYER
+	sortGiven := false
 	filterFound := false
 
 	queryItems := r.URL.Query()
@@ -72,6 +101,8 @@ func reqHandle(w http.ResponseWriter, r *http.Request) {
 
 			if strings.EqualFold(key, "version") {
 				tempParams.FVersion = values[0]
+			} else if strings.EqualFold(key, "latest") {
+				tempParams.LatestOnly = true
 			}
 
 			if strings.EqualFold(key, "vanilla") {
@@ -130,10 +161,48 @@ func reqHandle(w http.ResponseWriter, r *http.Request) {
 					}
 					tempParams.Searched = values[0]
 					tempParams.FPlayer = true
+
+				} else if strings.EqualFold(key, "search") {
+					filterFound = true
+					if values[0] == "" {
+						continue
+					}
+					tempParams.Searched = values[0]
+					tempParams.FText = true
+				}
+			}
+
+			//Player, time and mod count ranges
+			if found, err := setRangeParam(tempParams, key, values[0]); found {
+				if err != nil && tempParams.QueryError == "" {
+					tempParams.QueryError = err.Error()
+				}
+				continue
+			}
+
+			//Typo tolerant search, optionally with how many edits
+			if strings.EqualFold(key, "fuzzy") {
+				tempParams.Fuzzy = true
+				if val, err := strconv.Atoi(values[0]); err == nil {
+					tempParams.FuzzyTolerance = min(max(val, 0), MaxFuzzyTolerance)
+				}
+			}
+
+			//Filter query
+			if strings.EqualFold(key, "q") {
+				tempParams.Query = values[0]
+				query, err := parseQuery(values[0])
+				if err != nil {
+					tempParams.QueryError = err.Error()
+				} else {
+					tempParams.query = query
 				}
 			}
 
 			//Parse sorting arguments
+			if strings.HasPrefix(strings.ToLower(key), "sort-") {
+				sortGiven = true
+			}
 			if strings.EqualFold(key, "sort-players") {
 				sortBy = SORT_PLAYER
 				tempParams.SPlayers = true
@@ -146,6 +215,9 @@ func reqHandle(w http.ResponseWriter, r *http.Request) {
 			} else if strings.EqualFold(key, "sort-rtime") {
 				sortBy = SORT_RTIME
 				tempParams.SRTime = true
+			} else if strings.EqualFold(key, "sort-relevance") {
+				sortBy = SORT_RELEVANCE
+				tempParams.SRelevance = true
 			} else if strings.EqualFold(key, "page") {
 				val, err := strconv.ParseUint(values[0], 10, 64)
 				if err != nil {
@@ -156,10 +228,29 @@ func reqHandle(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 	}
+	if err := checkRanges(tempParams); err != nil && tempParams.QueryError == "" {
+		tempParams.QueryError = err.Error()
+	}
+
+	//Ranges like >=2.0.20, shown as their own choice in the dropdown
+	if tempParams.FVersion != "" {
+		if _, err := parseVersionRange(tempParams.FVersion); err != nil && tempParams.QueryError == "" {
+			tempParams.QueryError = "in version: " + err.Error()
+		}
+		tempParams.FVersionCustom = !versionInDropdown(tempParams.VersionGroups, tempParams.FVersion)
+	}
+
+	//Best fuzzy matches first, unless asked otherwise
+	if fuzzySearch(tempParams) && !sortGiven {
+		sortBy = SORT_RELEVANCE
+		tempParams.SRelevance = true
+	}
+
 	//Filter, sort, paginate
 	filterServers(tempParams)
-	//tempParams.ServerList.Servers = sortServers(!filterFound, tempParams.ServerList.Servers, sortBy)
-	tempParams.ServerList.Servers = sortServers(false, tempParams.ServerList.Servers, sortBy)
+	//tempParams.Servers = sortServers(!filterFound, tempParams.Servers, sortBy)
+	tempParams.Servers = sortServers(false, tempParams.Servers, sortBy)
+	tempParams.Facets = buildFacets(tempParams.Servers, queryItems)
 	paginateList(page, tempParams)
 
 	//Execute template
@@ -169,14 +260,90 @@ func reqHandle(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Server details request handler
+func serverDetailHandle(w http.ResponseWriter, r *http.Request) {
+	gameID, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/server/"))
+	if err != nil || gameID <= 0 || !knownGameID(gameID) {
+		http.NotFound(w, r)
+		return
+	}
+
+	//Log request
+	cwlog.DoLog(false, "Request: %v", r.RequestURI)
+
+	page := &ServerDetailPage{GameID: gameID}
+	page.Server, err = fetchServerDetails(gameID)
+	if err != nil {
+		page.Error = err.Error()
+	}
+
+	//Execute template
+	err = dTmpl.Execute(w, page)
+	if err != nil {
+		cwlog.DoLog(true, "Error: %v", err)
+	}
+}
+
+// Statistics page request handler
+func statsHandle(w http.ResponseWriter, r *http.Request) {
+	//Log request
+	cwlog.DoLog(false, "Request: %v", r.RequestURI)
+
+	page := buildStatsPage(r.URL.Query().Get("range"), time.Now().UTC())
+
+	//Execute template
+	err := sTmpl.Execute(w, page)
+	if err != nil {
+		cwlog.DoLog(true, "Error: %v", err)
+	}
+}
+
+// Player presence request handler
+func playerHandle(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/player")
+	name = strings.TrimPrefix(name, "/")
+	if name == "" {
+		name = r.URL.Query().Get("name")
+	}
+	name = strings.TrimSpace(name)
+
+	//Log request
+	cwlog.DoLog(false, "Request: %v", r.RequestURI)
+
+	page := &PlayerPage{Searched: name}
+	if name != "" {
+		if found := lookupPlayer(name); found != nil {
+			page = found
+			page.Searched = name
+		}
+	}
+
+	//Execute template
+	err := pTmpl.Execute(w, page)
+	if err != nil {
+		cwlog.DoLog(true, "Error: %v", err)
+	}
+}
+
 func filterServers(tempParams *ServerStateData) {
 	var tempServers []ServerListItem
-	lSearch := strings.ToLower(tempParams.Searched)
-	for _, server := range tempParams.ServerList.Servers {
+	query := append(legacyQuery(tempParams), tempParams.query...)
+
+	//Ranked search, the index numbers servers as they are before filtering
+	var scores map[int]int
+	if tempParams.FText {
+		scores = tempParams.index.search(tempParams.Searched)
+	}
+	fuzzy := fuzzySearch(tempParams)
+	var latest map[string]bool
+	if tempParams.LatestOnly {
+		latest = latestPatches(tempParams.VersionGroups)
+	}
+	for i, server := range tempParams.Servers {
 
 		//Order: Fastest compairsons that remove the most items first.
-		//Password
-		if !tempParams.AnyPass {
+		//Password, unless the query picks
+		if !tempParams.AnyPass && !query.has("password") {
 			if tempParams.HasPass && !server.Has_password {
 				continue
 			}
@@ -201,63 +368,69 @@ func filterServers(tempParams *ServerStateData) {
 			continue
 		}
 
-		//Version
-		if tempParams.FVersion != "" &&
-			server.Application_version.Game_version != tempParams.FVersion {
+		//Player count, map age and mod count
+		if !tempParams.playerRange.contains(server.Local.Players) ||
+			!tempParams.timeRange.contains(server.Local.Minutes) ||
+			!tempParams.modRange.contains(server.Mod_count) {
 			continue
 		}
 
-		//Search Type
-		if lSearch != "" {
-			if tempParams.FName {
-				if server.Name == "" {
-					continue
-				}
-				if !strings.Contains(strings.ToLower(server.Name), lSearch) {
-					continue
-				}
-			} else if tempParams.FDesc {
-				if server.Description == "" {
-					continue
-				}
-				if !strings.Contains(strings.ToLower(server.Description), lSearch) {
-					continue
-				}
-			} else if tempParams.FTag {
-				found := false
-				for _, tag := range server.Tags {
-					if tag == "" {
-						continue
-					}
-					if strings.Contains(strings.ToLower(tag), lSearch) {
-						found = true
-						break
-					}
-				}
-				if !found {
-					continue
-				}
-			} else if tempParams.FPlayer {
-				found := false
-				for _, player := range server.Players {
-					if player == "" {
-						continue
-					}
-					if strings.Contains(strings.ToLower(player), lSearch) {
-						found = true
-						break
-					}
-				}
-				if !found {
-					continue
-				}
+		//Newest patch of each minor
+		if latest != nil && !latest[server.Application_version.Game_version] {
+			continue
+		}
+
+		//Search and query terms
+		if fuzzy && !fuzzyServer(&server, tempParams) {
+			continue
+		}
+		if tempParams.FText {
+			if scores[i] == 0 {
+				continue
 			}
+			server.Local.Relevance = scores[i]
+		}
+		if !query.Match(&server) {
+			continue
 		}
 
 		tempServers = append(tempServers, server)
 	}
-	tempParams.ServerList.Servers = tempServers
-	tempParams.ServersCount = len(tempParams.ServerList.Servers)
+	tempParams.Servers = tempServers
+	tempParams.ServersCount = len(tempParams.Servers)
+}
+
+// The simple search and version parameters, as query terms
+func legacyQuery(tempParams *ServerStateData) serverQuery {
+	var query serverQuery
+	if tempParams.FVersion != "" {
+		//reqHandle reports a bad range, filter on what we can
+		if versions, err := parseVersionRange(tempParams.FVersion); err == nil {
+			query = append(query, queryTerm{Field: "version", Op: ":", Value: tempParams.FVersion, Versions: versions})
+		}
+	}
+	if tempParams.Searched == "" {
+		return query
+	}
+
+	//filterServers does these itself
+	if fuzzySearch(tempParams) {
+		return query
+	}
+
+	term := queryTerm{Op: ":", Value: strings.ToLower(tempParams.Searched)}
+	if tempParams.FName {
+		term.Field = "name"
+	} else if tempParams.FDesc {
+		term.Field = "desc"
+	} else if tempParams.FTag {
+		term.Field = "tag"
+	} else if tempParams.FPlayer {
+		term.Field = "player"
+	} else {
+		return query
+	}
+	return append(query, term)
 }
 
 // Present a single page of results
@@ -287,11 +460,11 @@ func paginateList(page int, tempParams *ServerStateData) {
 
 	//Put results into temp list
 	for c := pageStart; c < pageEnd; c++ {
-		tempServerList = append(tempServerList, tempParams.ServerList.Servers[c])
+		tempServerList = append(tempServerList, tempParams.Servers[c])
 	}
 
 	//Put results into page
-	tempParams.ServerList.Servers = tempServerList
+	tempParams.Servers = tempServerList
 	tempParams.NumPages = int(math.Ceil(float64(tempParams.ServersCount) / float64(tempParams.ItemsPerPage)))
 
 	//Handle invalid or nil page numbers
@@ -8,6 +8,9 @@ import (
 	"html/template"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 )
 
@@ -18,14 +21,22 @@ const (
 	UserAgent = ProgName + "-" + Version
 	VString   = ProgName + "v" + Version + " (" + VDate + ") "
 
-	//How long to wait for list server
-	ReqTimeout = time.Second * 3
 	//How often we can make a request, including on error.
 	ReqThrottle = time.Second * 30
-	//How often we can refresh when new requests come in
-	RefreshInterval = time.Minute * 5
+	//Minimum time between any two server detail requests
+	DetailThrottle = time.Second * 2
+	//Max number of server details kept in memory
+	DetailCacheSize = 500
 	//Timeout before our http(s) servers time out
 	ServerTimeout = 10 * time.Second
+)
+
+// Tunables, set from flags, environment or config file at startup
+var (
+	//How long to wait for list server
+	ReqTimeout = time.Second * 3
+	//How often we can refresh when new requests come in
+	RefreshInterval = time.Minute * 5
 
 	//How often to refresh if there are no requests
 	BGFetchInterval = time.Hour * 3
@@ -35,11 +46,17 @@ const (
 
 	//Servers per page
 	ItemsPerPage = 25
+
+	templateDir = "data"
+	wwwDir      = "data/www"
 )
 
 var (
-	sParam ServerStateData
-	tmpl   *template.Template
+	upstream UpstreamConfig
+	tmpl     *template.Template
+	dTmpl    *template.Template
+	sTmpl    *template.Template
+	pTmpl    *template.Template
 
 	bindIP        *string
 	bindPortHTTPS *int
@@ -48,26 +65,120 @@ var (
 	fileServer http.Handler
 )
 
+// Flags shared by every command, that aren't bound to a global
+type cliOptions struct {
+	sourceFile, replayDir, peers, retention *string
+}
+
 func main() {
+	//First argument picks the command, serve if it's a flag or missing
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	cmd := findCommand(name)
+	if cmd == nil {
+		printCommands()
+		os.Exit(2)
+		return
+	}
 
-	//Parse parameters
-	sParam = ServerStateData{UserAgent: UserAgent}
-	sParam.URL = flag.String("url", "multiplayer.factorio.com", "domain name to query")
-	sParam.Token = flag.String("token", "", "Matchmaking API token")
-	sParam.Username = flag.String("username", "", "Matchmaking API username")
+	fs := flag.NewFlagSet(ProgName+" "+cmd.Name, flag.ExitOnError)
+	opts := registerFlags(fs)
+	if cmd.Flags != nil {
+		cmd.Flags(fs)
+	}
+	fs.Parse(args)
 
-	bindIP = flag.String("ip", "", "IP to bind to")
-	bindPortHTTPS = flag.Int("httpsPort", 443, "port to bind to for HTTPS")
-	bindPortHTTP = flag.Int("httpPort", 80, "port to bind to")
-	flag.Parse()
+	//Command line, then environment, then config file
+	fs.Visit(func(f *flag.Flag) { cmdlineFlags[f.Name] = true })
+	if err := loadConfig(fs, nil); err != nil {
+		cwlog.DoLog(false, "Invalid config: %v", err)
+		os.Exit(1)
+		return
+	}
 
-	//Require token/username
-	if *sParam.Token == "" || *sParam.Username == "" {
-		cwlog.DoLog(false, "You must supply a username and token. -h for help.")
+	peerSources = parsePeers(*opts.peers)
+
+	var err error
+	historyRetention, err = parseRetention(*opts.retention)
+	if err != nil {
+		cwlog.DoLog(false, "Invalid history retention: %v", err)
 		os.Exit(1)
 		return
 	}
 
+	if err := cmd.Run(fs, opts); err != nil {
+		cwlog.DoLog(false, "%v: %v", cmd.Name, err)
+		os.Exit(1)
+	}
+}
+
+// Parse parameters
+func registerFlags(fs *flag.FlagSet) *cliOptions {
+	opts := &cliOptions{}
+
+	upstream.URL = fs.String("url", "multiplayer.factorio.com", "domain name to query")
+	upstream.Token = fs.String("token", "", "Matchmaking API token")
+	upstream.Username = fs.String("username", "", "Matchmaking API username")
+
+	bindIP = fs.String("ip", "", "IP to bind to")
+	bindPortHTTPS = fs.Int("httpsPort", 443, "port to bind to for HTTPS")
+	bindPortHTTP = fs.Int("httpPort", 80, "port to bind to")
+	opts.sourceFile = fs.String("sourceFile", "", "read the server list from a local JSON file instead of matchmaking")
+	opts.replayDir = fs.String("replayDir", "", "replay recorded get-games responses (*.json) from a directory")
+	opts.peers = fs.String("peers", "", "comma-separated goFactServView instances to mirror when matchmaking is down")
+	fs.IntVar(&peerFailover, "peerFailures", peerFailover, "failed fetches in a row before mirroring a peer")
+	fs.DurationVar(&peerMaxAge, "peerMaxAge", peerMaxAge, "don't mirror peer data fetched longer ago than this")
+	fs.Int64Var(&maxBodySize, "maxBody", maxBodySize, "most bytes to read from a server list source")
+	fs.Float64Var(&maxQuarantine, "maxQuarantine", maxQuarantine, "reject the list if more than this share of servers fail validation")
+	fs.Float64Var(&maxDrop, "maxDrop", maxDrop, "hold back a list that shrank by more than this share")
+	opts.retention = fs.String("historyRetention", DefaultRetention, "snapshot history and stats to keep, as age:resolution rules, or off")
+
+	fs.StringVar(&configPath, "config", configPath, "JSON file of settings, keyed by flag name")
+	fs.StringVar(&tokenFile, "token-file", "", "read the Matchmaking API token from this file")
+	fs.StringVar(&CacheFile, "cacheFile", CacheFile, "server list cache file")
+	fs.StringVar(&historyDir, "historyDir", historyDir, "directory for snapshot history")
+	fs.StringVar(&statsFile, "statsFile", statsFile, "stats history file")
+	fs.StringVar(&presenceFile, "presenceFile", presenceFile, "player sessions file")
+	fs.StringVar(&certDir, "certDir", certDir, "directory holding fullchain.pem and privkey.pem")
+	fs.StringVar(&cwlog.LogDir, "logDir", cwlog.LogDir, "directory for log files")
+	fs.StringVar(&templateDir, "templateDir", templateDir, "directory holding the page templates")
+	fs.StringVar(&wwwDir, "wwwDir", wwwDir, "directory of static files to serve")
+	fs.DurationVar(&RefreshInterval, "refreshInterval", RefreshInterval, "how often requests can trigger a refresh")
+	fs.DurationVar(&BGFetchInterval, "bgFetchInterval", BGFetchInterval, "how often to refresh when there are no requests")
+	fs.DurationVar(&ReqTimeout, "reqTimeout", ReqTimeout, "how long to wait for matchmaking")
+	fs.IntVar(&ItemsPerPage, "itemsPerPage", ItemsPerPage, "servers per page")
+	fs.IntVar(&MinValidCount, "minValidCount", MinValidCount, "reject lists with this many servers or fewer")
+	fs.IntVar(&FuzzyTolerance, "fuzzyTolerance", FuzzyTolerance, "edits a fuzzy name or player search allows by default")
+	fs.BoolVar(&offline, "offline", false, "serve only from the cache, or -sourceFile, and never contact matchmaking")
+
+	return opts
+}
+
+// Pick server list source
+func setupSource(opts *cliOptions) error {
+	if *opts.sourceFile != "" {
+		serverSource = &fileSource{Path: *opts.sourceFile}
+	} else if *opts.replayDir != "" {
+		src, err := newReplaySource(*opts.replayDir)
+		if err != nil {
+			return fmt.Errorf("unable to load replay: %w", err)
+		}
+		serverSource = src
+	} else if !offline && (*upstream.Token == "" || *upstream.Username == "") {
+		//Require token/username, unless we never contact matchmaking
+		return fmt.Errorf("you must supply a username and token. -h for help")
+	}
+	return nil
+}
+
+// Run the web server
+func runServe(fs *flag.FlagSet, opts *cliOptions) error {
+	if err := setupSource(opts); err != nil {
+		return err
+	}
+
 	//Defer to give log time to write on close
 	defer time.Sleep(time.Second * 2)
 	cwlog.StartLog()
@@ -76,19 +187,46 @@ func main() {
 	//Pretty time formatting
 	setupDurafmt()
 
-	//Read cache.json
-	ReadServerCache()
-	if err := fetchServerList(); err != nil {
-		cwlog.DoLog(true, "Initial fetch failed: %v", err)
+	//Read cache.json, or the offline snapshot
+	if offline {
+		if err := loadOffline(*opts.sourceFile); err != nil {
+			cwlog.DoLog(true, "Offline: %v", err)
+			return nil
+		}
+	} else {
+		ReadServerCache()
+	}
+	if len(historyRetention) > 0 {
+		if err := loadHistoryIndex(); err != nil {
+			cwlog.DoLog(true, "Unable to read history: %v", err)
+		}
+		loadPlayerCounts(time.Now())
+		if err := loadStats(); err != nil {
+			cwlog.DoLog(true, "Unable to read stats: %v", err)
+		}
+		if err := loadPresence(); err != nil {
+			cwlog.DoLog(true, "Unable to read player sessions: %v", err)
+		}
+		//Only once everything is loaded, it saves over the files
+		go backgroundCompactHistory()
+	}
+	if !offline {
+		RefreshLock.Lock()
+		if err := fetchServerList(); err != nil {
+			cwlog.DoLog(true, "Initial fetch failed: %v", err)
+		}
+		RefreshLock.Unlock()
 	}
 
 	//Parse template.html
 	parseTemplate()
 
 	//HTTP(s) fileserver
-	fileServer = http.FileServer(http.Dir("data/www"))
+	fileServer = http.FileServer(http.Dir(wwwDir))
 
 	go backgroundUpdateList()
+	go watchConfigReload(fs)
+	go watchShutdown()
 
 	//HTTP listen
 	go func() {
@@ -102,7 +240,7 @@ func main() {
 
 	if err := loadCerts(); err != nil {
 		cwlog.DoLog(true, "%v", err)
-		return
+		return nil
 	}
 	config := &tls.Config{
 		GetCertificate:     getCertificate,
@@ -127,8 +265,26 @@ func main() {
 	err := server.ListenAndServeTLS("", "")
 	if err != nil {
 		cwlog.DoLog(true, "ListenAndServeTLS: %v", err)
-		return
+		return nil
 	}
 
 	cwlog.DoLog(true, "Goodbye.")
+	return nil
+}
+
+// Save player sessions before exiting on SIGINT or SIGTERM
+func watchShutdown() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+
+	if len(historyRetention) > 0 {
+		if err := savePresence(); err != nil {
+			cwlog.DoLog(true, "savePresence: %v", err)
+		}
+	}
+	cwlog.DoLog(true, "Goodbye.")
+	//Give log time to write
+	time.Sleep(time.Second * 2)
+	os.Exit(0)
 }
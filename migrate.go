@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"goFactServView/cwlog"
+	"html"
+	"strings"
+)
+
+// Oldest cache version we can still upgrade
+const MinCacheVersion = 2
+
+// Upgrades a raw cache from version N to N+1, keyed by N.
+// Works on generic JSON, so older shapes don't need their own types.
+var cacheMigrations = map[int]func(cache map[string]any) error{
+	2: migrateCacheV2,
+	3: migrateCacheV3,
+}
+
+// Parse a cache file of any supported version, upgrading it step by step
+func migrateCache(body []byte) (CacheData, error) {
+	cache := map[string]any{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&cache); err != nil {
+		return CacheData{}, fmt.Errorf("invalid cache JSON: %w", err)
+	}
+
+	version, err := cacheVersion(cache)
+	if err != nil {
+		return CacheData{}, err
+	}
+	if version > CacheVersion {
+		return CacheData{}, fmt.Errorf("cache version %d is newer than this build supports (%d), refusing to load it", version, CacheVersion)
+	}
+	if version < MinCacheVersion {
+		return CacheData{}, fmt.Errorf("cache version %d is too old to upgrade (oldest supported: %d)", version, MinCacheVersion)
+	}
+
+	for ; version < CacheVersion; version++ {
+		migrate := cacheMigrations[version]
+		if migrate == nil {
+			return CacheData{}, fmt.Errorf("no migration from cache version %d", version)
+		}
+		if err := migrate(cache); err != nil {
+			return CacheData{}, fmt.Errorf("migrating cache from version %d: %w", version, err)
+		}
+		cache["Version"] = version + 1
+		cwlog.DoLog(false, "Migrated cache from version %v to %v.", version, version+1)
+	}
+
+	//Now in the current shape
+	upgraded, err := json.Marshal(cache)
+	if err != nil {
+		return CacheData{}, err
+	}
+	result := CacheData{}
+	if err := json.Unmarshal(upgraded, &result); err != nil {
+		return CacheData{}, fmt.Errorf("invalid cache after migration: %w", err)
+	}
+	return result, nil
+}
+
+func cacheVersion(cache map[string]any) (int, error) {
+	num, ok := cache["Version"].(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("cache has no version")
+	}
+	version, err := num.Int64()
+	if err != nil {
+		return 0, fmt.Errorf("bad cache version %v", num)
+	}
+	return int(version), nil
+}
+
+// Servers in a raw cache, as generic objects
+func cacheServers(cache map[string]any) []map[string]any {
+	list, _ := cache["Servers"].([]any)
+	servers := make([]map[string]any, 0, len(list))
+	for _, item := range list {
+		if server, ok := item.(map[string]any); ok {
+			servers = append(servers, server)
+		}
+	}
+	return servers
+}
+
+// v3 added rich text HTML. v2 only kept plain text, so escape that.
+func migrateCacheV2(cache map[string]any) error {
+	for _, server := range cacheServers(cache) {
+		local, _ := server["Local"].(map[string]any)
+		if local == nil {
+			local = map[string]any{}
+			server["Local"] = local
+		}
+
+		name, _ := server["Name"].(string)
+		desc, _ := server["Description"].(string)
+		local["NameHTML"] = html.EscapeString(name)
+		local["DescHTML"] = html.EscapeString(strings.TrimSpace(desc))
+
+		tags, _ := server["Tags"].([]any)
+		tagsHTML := make([]any, 0, len(tags))
+		for _, tag := range tags {
+			text, _ := tag.(string)
+			tagsHTML = append(tagsHTML, html.EscapeString(text))
+		}
+		local["TagsHTML"] = tagsHTML
+	}
+	return nil
+}
+
+// v4 added a checksum. Older files have none, so there's nothing to check.
+func migrateCacheV3(cache map[string]any) error {
+	return nil
+}
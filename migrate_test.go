@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMigrateCacheFixtures(t *testing.T) {
+	//Every version we claim to upgrade needs a fixture
+	for version := MinCacheVersion; version <= CacheVersion; version++ {
+		t.Run(fmt.Sprintf("v%d", version), func(t *testing.T) {
+			body, err := os.ReadFile(filepath.Join("testdata", fmt.Sprintf("cache_v%d.json", version)))
+			if err != nil {
+				t.Fatalf("missing fixture: %v", err)
+			}
+
+			if err := verifyCacheChecksum(body); err != nil {
+				t.Fatalf("verifyCacheChecksum returned error: %v", err)
+			}
+			cache, err := migrateCache(body)
+			if err != nil {
+				t.Fatalf("migrateCache returned error: %v", err)
+			}
+			if cache.Version != CacheVersion {
+				t.Fatalf("expected version %d, got %d", CacheVersion, cache.Version)
+			}
+			if len(cache.Servers) != 3 {
+				t.Fatalf("expected 3 servers, got %d", len(cache.Servers))
+			}
+			for _, server := range cache.Servers {
+				if server.Local.NameHTML == "" {
+					t.Fatalf("server %q has no NameHTML", server.Name)
+				}
+				//Fixtures should look like what we write
+				if want := MakeSteamURL(server.Host_address); server.Local.ConnectURL != want {
+					t.Fatalf("server %q has ConnectURL %q, want %q", server.Name, server.Local.ConnectURL, want)
+				}
+				if len(server.Local.TagsHTML) != len(server.Tags) {
+					t.Fatalf("server %q has %d tags but %d tag HTML", server.Name, len(server.Tags), len(server.Local.TagsHTML))
+				}
+			}
+		})
+	}
+}
+
+func TestMigrateCacheV2EscapesText(t *testing.T) {
+	body, err := os.ReadFile(filepath.Join("testdata", "cache_v2.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err := migrateCache(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alpha := cache.Servers[0]
+	if alpha.Local.NameHTML != "Alpha &lt;base&gt;" {
+		t.Fatalf("expected escaped name, got %q", alpha.Local.NameHTML)
+	}
+	if alpha.Local.TagsHTML[1] != "pvp &amp; co" {
+		t.Fatalf("expected escaped tag, got %q", alpha.Local.TagsHTML[1])
+	}
+	if alpha.Local.ConnectURL == "" || alpha.Local.Minutes != 120 {
+		t.Fatal("expected existing local data to be kept")
+	}
+}
+
+func TestMigrateCacheRefusesNewer(t *testing.T) {
+	body := fmt.Sprintf(`{"Version": %d, "Servers": []}`, CacheVersion+1)
+	_, err := migrateCache([]byte(body))
+	if err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Fatalf("expected newer version error, got %v", err)
+	}
+}
+
+func TestMigrateCacheRefusesTooOld(t *testing.T) {
+	body := fmt.Sprintf(`{"Version": %d, "Servers": []}`, MinCacheVersion-1)
+	if _, err := migrateCache([]byte(body)); err == nil {
+		t.Fatal("expected error for unsupported old version")
+	}
+	if _, err := migrateCache([]byte(`{"Servers": []}`)); err == nil {
+		t.Fatal("expected error for missing version")
+	}
+}
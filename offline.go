@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"goFactServView/cwlog"
+	"os"
+)
+
+var (
+	//Serve what we have on disk, never contact matchmaking or peers
+	offline bool
+
+	errOffline = errors.New("not available in offline mode")
+)
+
+// Load the list once for offline mode, from path if given, otherwise the cache
+func loadOffline(path string) error {
+	if path == "" {
+		ReadServerCache()
+		if getSnapshot().ServersCount == 0 {
+			return fmt.Errorf("no usable cache at %v", CacheFile)
+		}
+		return nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	src := &fileSource{Path: path}
+	snap, err := fetchFromSource(src, getSnapshot())
+	if err != nil {
+		return fmt.Errorf("%v: %w", src.Name(), err)
+	}
+
+	//The data is as old as the file, not the time we read it
+	snap.FetchedAt = info.ModTime().UTC()
+	publishSnapshot(snap)
+	cwlog.DoLog(true, "Offline: loaded %v servers from %v.", snap.ServersCount, path)
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOfflineServesFileWithoutFetching(t *testing.T) {
+	setupDurafmt()
+	parseTemplate()
+
+	restore := configureFetchTestState(t)
+	defer restore()
+	offline = true
+	defer func() { offline = false }()
+
+	//Fail loudly if anything tries to fetch
+	calls := 0
+	serverSource = &countingSource{stubSource: stubSource{err: errOffline}, calls: &calls}
+
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	writeTestFile(t, path, makeServerListJSON(MinValidCount+2))
+	fileTime := time.Now().Add(-3 * time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(path, fileTime, fileTime); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := loadOffline(path); err != nil {
+		t.Fatalf("loadOffline returned error: %v", err)
+	}
+	snap := getSnapshot()
+	if snap.ServersCount != MinValidCount+2 {
+		t.Fatalf("expected %d servers, got %d", MinValidCount+2, snap.ServersCount)
+	}
+	if !snap.FetchedAt.Equal(fileTime) {
+		t.Fatalf("expected data age from file time %v, got %v", fileTime, snap.FetchedAt)
+	}
+
+	rec := httptest.NewRecorder()
+	reqHandle(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if !strings.Contains(rec.Body.String(), "Offline: data is 3 hrs old") {
+		t.Fatal("expected page to show how old the offline data is")
+	}
+
+	RefreshLock.Lock()
+	fetchServerList()
+	RefreshLock.Unlock()
+	triggerRefresh()
+	if refreshing.Load() || calls != 0 {
+		t.Fatalf("expected no fetches in offline mode, got %d", calls)
+	}
+
+	if _, err := fetchServerDetails(1); err != errOffline {
+		t.Fatalf("expected errOffline for details, got %v", err)
+	}
+}
+
+func TestOfflineNeedsData(t *testing.T) {
+	restore := configureFetchTestState(t)
+	defer restore()
+	restoreCache := configureCacheTestState(t)
+	defer restoreCache()
+
+	if err := loadOffline(""); err == nil {
+		t.Fatal("expected error without a cache")
+	}
+	if err := loadOffline(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Fatal("expected error for missing snapshot file")
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"goFactServView/cwlog"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	//Peer instances to mirror from
+	peerSources []ServerSource
+	//Primary failures in a row before we try peers
+	peerFailover = 3
+	//Oldest peer data we'll mirror
+	peerMaxAge = 15 * time.Minute
+)
+
+// Another goFactServView instance, via its /snapshot.json
+type peerSource struct {
+	BaseURL string
+	//When the peer fetched the last list we got from it
+	fetchedAt time.Time
+}
+
+// Parse a comma-separated list of peers
+func parsePeers(input string) []ServerSource {
+	var peers []ServerSource
+	for _, peer := range strings.Split(input, ",") {
+		peer = strings.TrimRight(strings.TrimSpace(peer), "/")
+		if peer == "" {
+			continue
+		}
+		peers = append(peers, &peerSource{BaseURL: buildBaseURL(peer)})
+	}
+	return peers
+}
+
+func (src *peerSource) Name() string {
+	return "peer " + src.BaseURL
+}
+
+func (src *peerSource) Fetch() ([]ServerListItem, error) {
+
+	//HTTP GET
+	req, err := http.NewRequest(http.MethodGet, src.BaseURL+"/snapshot.json", nil)
+	if err != nil {
+		return nil, fmt.Errorf("request build failed: %w", err)
+	}
+
+	req.Header.Set("User-Agent", UserAgent)
+
+	//Get response
+	res, getErr := fetchHTTPClient().Do(req)
+	if getErr != nil {
+		return nil, fmt.Errorf("request failed: %w", getErr)
+	}
+
+	//Close once complete, if valid
+	if res.Body != nil {
+		defer res.Body.Close()
+	}
+
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		return nil, fmt.Errorf("unexpected peer status %d: %s", res.StatusCode, shortenBody(body))
+	}
+
+	snapshot, err := decodeCacheData(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid snapshot: %w", err)
+	}
+
+	//Don't mirror a mirror, the data could be very old
+	if snapshot.Mirrored {
+		return nil, fmt.Errorf("peer is serving mirrored data")
+	}
+	//Nor a peer that's offline, or can't reach matchmaking either
+	if snapshot.FetchedAt.IsZero() {
+		return nil, fmt.Errorf("peer didn't say when its data was fetched")
+	}
+	if age := time.Since(snapshot.FetchedAt); age > peerMaxAge {
+		return nil, fmt.Errorf("peer data is %v old, limit is %v", age.Round(time.Second), peerMaxAge)
+	}
+	src.fetchedAt = snapshot.FetchedAt.UTC()
+	return snapshot.Servers, nil
+}
+
+func (src *peerSource) FetchedAt() time.Time {
+	return src.fetchedAt
+}
+
+// Try each peer in order, until one works
+func fetchFromPeers(old *Snapshot) (*Snapshot, ServerSource, error) {
+	var lastErr error
+	for _, peer := range peerSources {
+		snap, err := fetchFromSource(peer, old)
+		if err == nil {
+			//Keep the peer's fetch time, the list is no newer than that
+			if ds, ok := peer.(datedSource); ok {
+				snap.FetchedAt = ds.FetchedAt()
+			}
+			if !snap.FetchedAt.After(old.FetchedAt) {
+				err = fmt.Errorf("peer data is no newer than ours")
+			}
+		}
+		if err == nil {
+			return snap, peer, nil
+		}
+		cwlog.DoLog(true, "fetchFromPeers: %v: %v", peer.Name(), err)
+		lastErr = err
+	}
+	return nil, nil, lastErr
+}
+
+// Serve our current snapshot to peers
+func snapshotHandle(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	snap := getSnapshot()
+	data := snapshotCacheData(snap)
+	data.FetchedAt = snap.FetchedAt
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		cwlog.DoLog(true, "snapshotHandle: %v", err)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type stubSource struct {
+	list []ServerListItem
+	err  error
+}
+
+func (src *stubSource) Name() string {
+	return "stub"
+}
+
+func (src *stubSource) Fetch() ([]ServerListItem, error) {
+	return src.list, src.err
+}
+
+func TestFetchServerListMirrorsPeerAfterFailures(t *testing.T) {
+	setupDurafmt()
+
+	peerServers := processServerList(seedServers(MinValidCount + 4))
+	peerFetched := time.Now().Add(-time.Minute).UTC().Truncate(time.Second)
+	peer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/snapshot.json" {
+			t.Fatalf("unexpected path: %s", r.URL.Path)
+		}
+		json.NewEncoder(w).Encode(CacheData{Version: CacheVersion, Servers: peerServers, FetchedAt: peerFetched})
+	}))
+	defer peer.Close()
+
+	restore := configurePeerTestState(t)
+	defer restore()
+
+	primary := &stubSource{err: errors.New("boom")}
+	serverSource = primary
+	peerSources = parsePeers(peer.URL)
+	peerFailover = 2
+	fetchHTTPClient = func() *http.Client {
+		return peer.Client()
+	}
+
+	//First failure, not enough to mirror yet
+	if err := fetchServerList(); err == nil {
+		t.Fatal("expected error before failover")
+	}
+	if getSnapshot().Mirrored {
+		t.Fatal("expected no mirroring after one failure")
+	}
+
+	lastAttempt.Store(0)
+	if err := fetchServerList(); err != nil {
+		t.Fatalf("expected peer fallback, got %v", err)
+	}
+	if !getSnapshot().Mirrored || getSnapshot().ServersCount != MinValidCount+4 {
+		t.Fatalf("expected mirrored peer data, got mirrored=%v count=%d", getSnapshot().Mirrored, getSnapshot().ServersCount)
+	}
+	if !getSnapshot().FetchedAt.Equal(peerFetched) {
+		t.Fatalf("expected the peer's fetch time %v, got %v", peerFetched, getSnapshot().FetchedAt)
+	}
+
+	//Primary recovers
+	primary.err = nil
+	primary.list = seedServers(MinValidCount + 1)
+	markStale()
+	if err := fetchServerList(); err != nil {
+		t.Fatalf("fetchServerList returned error: %v", err)
+	}
+	if getSnapshot().Mirrored || breaker.Failures != 0 || getSnapshot().ServersCount != MinValidCount+1 {
+		t.Fatalf("expected primary data, got mirrored=%v failures=%d count=%d",
+			getSnapshot().Mirrored, breaker.Failures, getSnapshot().ServersCount)
+	}
+}
+
+func TestPeerSourceRefusesMirroredSnapshot(t *testing.T) {
+	peer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(CacheData{Version: CacheVersion, Servers: seedServers(MinValidCount + 1), Mirrored: true})
+	}))
+	defer peer.Close()
+
+	restore := configurePeerTestState(t)
+	defer restore()
+
+	fetchHTTPClient = func() *http.Client {
+		return peer.Client()
+	}
+
+	if _, err := (&peerSource{BaseURL: peer.URL}).Fetch(); err == nil {
+		t.Fatal("expected mirrored snapshot to be refused")
+	}
+}
+
+func TestPeerSourceRefusesOldSnapshot(t *testing.T) {
+	var fetchedAt time.Time
+	peer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(CacheData{Version: CacheVersion, Servers: seedServers(MinValidCount + 1), FetchedAt: fetchedAt})
+	}))
+	defer peer.Close()
+
+	restore := configurePeerTestState(t)
+	defer restore()
+
+	fetchHTTPClient = func() *http.Client {
+		return peer.Client()
+	}
+	src := &peerSource{BaseURL: peer.URL}
+
+	//No fetch time, from an older peer
+	if _, err := src.Fetch(); err == nil {
+		t.Fatal("expected a snapshot without a fetch time to be refused")
+	}
+
+	fetchedAt = time.Now().Add(-peerMaxAge - time.Minute)
+	if _, err := src.Fetch(); err == nil || !strings.Contains(err.Error(), "old") {
+		t.Fatalf("expected old snapshot to be refused, got %v", err)
+	}
+
+	fetchedAt = time.Now().Add(-time.Minute)
+	if _, err := src.Fetch(); err != nil {
+		t.Fatalf("expected fresh snapshot, got %v", err)
+	}
+	if !src.FetchedAt().Equal(fetchedAt) {
+		t.Fatalf("expected fetch time %v, got %v", fetchedAt, src.FetchedAt())
+	}
+}
+
+func TestSnapshotHandleSendsFetchTime(t *testing.T) {
+	restore := configurePeerTestState(t)
+	defer restore()
+
+	fetchedAt := time.Unix(1700000000, 0).UTC()
+	publishSnapshot(newSnapshot(seedServers(2), fetchedAt))
+
+	rec := httptest.NewRecorder()
+	snapshotHandle(rec, httptest.NewRequest(http.MethodGet, "/snapshot.json", nil))
+	data := CacheData{}
+	if err := json.NewDecoder(rec.Body).Decode(&data); err != nil {
+		t.Fatal(err)
+	}
+	if !data.FetchedAt.Equal(fetchedAt) || len(data.Servers) != 2 {
+		t.Fatalf("expected 2 servers fetched at %v, got %d at %v", fetchedAt, len(data.Servers), data.FetchedAt)
+	}
+}
+
+func configurePeerTestState(t *testing.T) func() {
+	t.Helper()
+
+	restoreFetch := configureFetchTestState(t)
+	oldPeers := peerSources
+	oldFailover := peerFailover
+	oldMaxAge := peerMaxAge
+
+	return func() {
+		restoreFetch()
+		peerSources = oldPeers
+		peerFailover = oldFailover
+		peerMaxAge = oldMaxAge
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"goFactServView/cwlog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	//How long player sessions are kept
+	PresenceWindow = 7 * 24 * time.Hour
+	//Sightings further apart than this are separate sessions
+	MaxSessionGap = time.Hour
+	//Sessions listed on a player page
+	MaxPlayerSessions = 50
+)
+
+var (
+	presenceFile = "data/presence.json.gz"
+
+	//Protects presence, lastPresenceFetch and presenceLoaded
+	PresenceLock sync.RWMutex
+	//Sessions by lower case player name
+	presence = map[string]*playerRecord{}
+	//When we last recorded presence, sessions ending here can continue
+	lastPresenceFetch time.Time
+	//Don't save until loadPresence has run, or we'd write over the saved sessions
+	presenceLoaded bool
+)
+
+type playerRecord struct {
+	Name     string
+	Sessions []playerSession
+}
+
+// A run of consecutive sightings of a player on one server
+type playerSession struct {
+	ServerKey  string
+	ServerName string
+	Host       string
+	Start, End time.Time
+}
+
+// What we save to presenceFile
+type presenceData struct {
+	LastFetch time.Time
+	Players   []*playerRecord
+}
+
+type PlayerPage struct {
+	Name     string
+	Searched string
+	Servers  []PlayerServerSummary
+	Sessions []PlayerSessionView
+}
+
+// Everything we know about a player on one server
+type PlayerServerSummary struct {
+	Name, Host string
+	Online     bool
+	Sessions   int
+	Playtime   string
+	LastSeen   string
+	lastSeen   time.Time
+}
+
+type PlayerSessionView struct {
+	ServerName string
+	Start, End string
+	Length     string
+}
+
+// Playtime estimate for one session, first to last sighting
+// plus one refresh, as players were there around each sighting
+func (s playerSession) playtime() time.Duration {
+	return s.End.Sub(s.Start) + RefreshInterval
+}
+
+// Extend sessions for players seen in the last fetch, start new ones for the rest
+func recordPresence(servers []ServerListItem, at time.Time) {
+	PresenceLock.Lock()
+	defer PresenceLock.Unlock()
+
+	prev := lastPresenceFetch
+	continues := !prev.IsZero() && at.After(prev) && at.Sub(prev) <= MaxSessionGap
+
+	for _, item := range servers {
+		key := serverKey(item)
+		for _, name := range item.Players {
+			if name == "" {
+				continue
+			}
+			rec := presence[strings.ToLower(name)]
+			if rec == nil {
+				rec = &playerRecord{Name: name}
+				presence[strings.ToLower(name)] = rec
+			}
+
+			extended := false
+			if continues {
+				for s := len(rec.Sessions) - 1; s >= 0 && !rec.Sessions[s].End.Before(prev); s-- {
+					if rec.Sessions[s].ServerKey == key {
+						rec.Sessions[s].End = at
+						extended = true
+						break
+					}
+				}
+			}
+			if !extended {
+				rec.Sessions = append(rec.Sessions, playerSession{
+					ServerKey:  key,
+					ServerName: item.Name,
+					Host:       item.Host_address,
+					Start:      at,
+					End:        at,
+				})
+			}
+		}
+	}
+	lastPresenceFetch = at
+	prunePresence(at)
+}
+
+// Forget sessions past PresenceWindow. Callers should hold PresenceLock.
+func prunePresence(now time.Time) {
+	cutoff := now.Add(-PresenceWindow)
+	for key, rec := range presence {
+		start := 0
+		for start < len(rec.Sessions) && rec.Sessions[start].End.Before(cutoff) {
+			start++
+		}
+		if start == len(rec.Sessions) {
+			delete(presence, key)
+		} else if start > 0 {
+			rec.Sessions = append([]playerSession(nil), rec.Sessions[start:]...)
+		}
+	}
+}
+
+// Where and when a player was seen, nil if never
+func lookupPlayer(name string) *PlayerPage {
+	PresenceLock.RLock()
+	defer PresenceLock.RUnlock()
+
+	rec := presence[strings.ToLower(strings.TrimSpace(name))]
+	if rec == nil {
+		return nil
+	}
+
+	page := &PlayerPage{Name: rec.Name}
+	byServer := map[string]*PlayerServerSummary{}
+	playtime := map[string]time.Duration{}
+
+	for _, session := range rec.Sessions {
+		summary := byServer[session.ServerKey]
+		if summary == nil {
+			summary = &PlayerServerSummary{Name: session.ServerName, Host: session.Host}
+			byServer[session.ServerKey] = summary
+		}
+		summary.Sessions++
+		playtime[session.ServerKey] += session.playtime()
+		if session.End.After(summary.lastSeen) {
+			summary.lastSeen = session.End
+		}
+		if session.End.Equal(lastPresenceFetch) {
+			summary.Online = true
+		}
+	}
+
+	for key, summary := range byServer {
+		summary.Playtime = updateTime(int(playtime[key].Minutes()))
+		summary.LastSeen = summary.lastSeen.Format(time.RFC1123)
+		page.Servers = append(page.Servers, *summary)
+	}
+	sort.Slice(page.Servers, func(i, j int) bool {
+		return page.Servers[i].lastSeen.After(page.Servers[j].lastSeen)
+	})
+
+	//Most recent first
+	for s := len(rec.Sessions) - 1; s >= 0 && len(page.Sessions) < MaxPlayerSessions; s-- {
+		session := rec.Sessions[s]
+		page.Sessions = append(page.Sessions, PlayerSessionView{
+			ServerName: session.ServerName,
+			Start:      session.Start.Format(time.RFC1123),
+			End:        session.End.Format(time.RFC1123),
+			Length:     updateTime(int(session.playtime().Minutes())),
+		})
+	}
+	return page
+}
+
+// Save sessions, so a restart doesn't lose them
+func savePresence() error {
+	PresenceLock.RLock()
+	if !presenceLoaded {
+		PresenceLock.RUnlock()
+		return nil
+	}
+	data := presenceData{LastFetch: lastPresenceFetch}
+	for _, rec := range presence {
+		data.Players = append(data.Players, rec)
+	}
+
+	tempPath := presenceFile + ".tmp"
+	file, err := os.Create(tempPath)
+	if err != nil {
+		PresenceLock.RUnlock()
+		return err
+	}
+	gz := gzip.NewWriter(file)
+	err = json.NewEncoder(gz).Encode(data)
+	PresenceLock.RUnlock()
+
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return os.Rename(tempPath, presenceFile)
+}
+
+// Read saved sessions from disk
+func loadPresence() error {
+	//Even if it fails, so new sessions still get saved
+	defer func() {
+		PresenceLock.Lock()
+		presenceLoaded = true
+		PresenceLock.Unlock()
+	}()
+
+	file, err := os.Open(presenceFile)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	data := presenceData{}
+	if err := json.NewDecoder(gz).Decode(&data); err != nil {
+		return err
+	}
+
+	PresenceLock.Lock()
+	defer PresenceLock.Unlock()
+
+	presence = map[string]*playerRecord{}
+	for _, rec := range data.Players {
+		presence[strings.ToLower(rec.Name)] = rec
+	}
+	lastPresenceFetch = data.LastFetch
+	prunePresence(time.Now())
+	cwlog.DoLog(false, "Loaded sessions for %v players.", len(presence))
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func configurePresenceTestState(t *testing.T) func() {
+	t.Helper()
+
+	oldPresence, oldLast, oldFile, oldLoaded := presence, lastPresenceFetch, presenceFile, presenceLoaded
+	presence = map[string]*playerRecord{}
+	lastPresenceFetch = time.Time{}
+	presenceFile = filepath.Join(t.TempDir(), "presence.json.gz")
+	presenceLoaded = true
+
+	return func() {
+		presence, lastPresenceFetch, presenceFile, presenceLoaded = oldPresence, oldLast, oldFile, oldLoaded
+	}
+}
+
+func presenceServers(players ...[]string) []ServerListItem {
+	servers := seedServers(len(players))
+	for i := range servers {
+		servers[i].Players = players[i]
+	}
+	return servers
+}
+
+func TestRecordPresenceBuildsSessions(t *testing.T) {
+	setupDurafmt()
+	restore := configurePresenceTestState(t)
+	defer restore()
+
+	now := time.Now().UTC().Truncate(time.Minute)
+	step := 5 * time.Minute
+
+	//Alice plays on seed-1 for three fetches, moves to seed-2, then comes back after a long gap
+	recordPresence(presenceServers([]string{"Alice"}, nil), now)
+	recordPresence(presenceServers([]string{"alice"}, nil), now.Add(step))
+	recordPresence(presenceServers([]string{"Alice"}, nil), now.Add(2*step))
+	recordPresence(presenceServers(nil, []string{"Alice"}), now.Add(3*step))
+	recordPresence(presenceServers([]string{"Alice"}, nil), now.Add(3*step+2*MaxSessionGap))
+
+	sessions := presence["alice"].Sessions
+	if len(sessions) != 3 {
+		t.Fatalf("expected 3 sessions, got %d", len(sessions))
+	}
+	if !sessions[0].Start.Equal(now) || !sessions[0].End.Equal(now.Add(2*step)) {
+		t.Fatalf("unexpected first session %v - %v", sessions[0].Start, sessions[0].End)
+	}
+
+	page := lookupPlayer("ALICE")
+	if page == nil || page.Name != "Alice" {
+		t.Fatalf("expected to find Alice, got %+v", page)
+	}
+	if len(page.Servers) != 2 {
+		t.Fatalf("expected 2 servers, got %d", len(page.Servers))
+	}
+	if page.Servers[0].Name != "seed-1" || !page.Servers[0].Online || page.Servers[0].Sessions != 2 {
+		t.Fatalf("unexpected summary %+v", page.Servers[0])
+	}
+	if page.Sessions[0].ServerName != "seed-1" {
+		t.Fatalf("expected most recent session first, got %+v", page.Sessions[0])
+	}
+	if lookupPlayer("bob") != nil {
+		t.Fatal("expected no record for unseen player")
+	}
+}
+
+func TestPresenceSaveAndLoad(t *testing.T) {
+	restore := configurePresenceTestState(t)
+	defer restore()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	recordPresence(presenceServers([]string{"alice", "bob"}), now)
+	if err := savePresence(); err != nil {
+		t.Fatalf("savePresence returned error: %v", err)
+	}
+
+	presence = map[string]*playerRecord{}
+	lastPresenceFetch = time.Time{}
+	if err := loadPresence(); err != nil {
+		t.Fatalf("loadPresence returned error: %v", err)
+	}
+	if len(presence) != 2 || !lastPresenceFetch.Equal(now) {
+		t.Fatalf("expected 2 players at %v, got %d at %v", now, len(presence), lastPresenceFetch)
+	}
+}
+
+func TestPresenceSurvivesRestart(t *testing.T) {
+	restore := configurePresenceTestState(t)
+	defer restore()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	recordPresence(presenceServers([]string{"alice"}), now)
+	if err := savePresence(); err != nil {
+		t.Fatalf("savePresence returned error: %v", err)
+	}
+
+	//Restart: nothing in memory, and a save before loading
+	presence = map[string]*playerRecord{}
+	lastPresenceFetch = time.Time{}
+	presenceLoaded = false
+	if err := savePresence(); err != nil {
+		t.Fatalf("savePresence before load returned error: %v", err)
+	}
+	if err := loadPresence(); err != nil {
+		t.Fatalf("loadPresence returned error: %v", err)
+	}
+	if lookupPlayer("alice") == nil || !lastPresenceFetch.Equal(now) {
+		t.Fatal("expected alice's session to survive the restart")
+	}
+
+	//Saves after loading go through
+	recordPresence(presenceServers([]string{"bob"}), now.Add(time.Minute))
+	if err := savePresence(); err != nil {
+		t.Fatalf("savePresence returned error: %v", err)
+	}
+	presence = map[string]*playerRecord{}
+	if err := loadPresence(); err != nil {
+		t.Fatalf("loadPresence returned error: %v", err)
+	}
+	if lookupPlayer("alice") == nil || lookupPlayer("bob") == nil {
+		t.Fatal("expected both players after the second restart")
+	}
+}
+
+func TestPlayerPageHandler(t *testing.T) {
+	setupDurafmt()
+	parseTemplate()
+	restore := configurePresenceTestState(t)
+	defer restore()
+
+	recordPresence(presenceServers([]string{"alice"}), time.Now().UTC())
+
+	rec := httptest.NewRecorder()
+	reqHandle(rec, httptest.NewRequest(http.MethodGet, "/player/alice", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "seed-1") {
+		t.Fatalf("expected player page listing seed-1, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	reqHandle(rec, httptest.NewRequest(http.MethodGet, "/player?name=nobody", nil))
+	if !strings.Contains(rec.Body.String(), "hasn't been seen") {
+		t.Fatalf("expected not seen message, got %q", rec.Body.String())
+	}
+}
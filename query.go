@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Filter query, like: name:space tag:pvp players>=5 version>=2.0.20 -tag:whitelist
+// Terms are ANDed, a leading - negates one. Text fields match anywhere with :,
+// or the whole value with =, like tag=pvp.
+
+// Fields a query term can use, and whether they take numbers
+var queryFields = map[string]bool{
+	"name":     false,
+	"desc":     false,
+	"tag":      false,
+	"player":   false,
+	"version":  false,
+	"password": false,
+	"modded":   false,
+	"players":  true,
+	"mods":     true,
+	"minutes":  true,
+}
+
+// Field order for error messages
+var queryFieldNames = []string{"name", "desc", "tag", "player", "version", "players", "mods", "minutes", "password", "modded"}
+
+var queryFieldAliases = map[string]string{
+	"description": "desc",
+	"tags":        "tag",
+	"ver":         "version",
+	"mod":         "mods",
+	"time":        "minutes",
+}
+
+type queryTerm struct {
+	//Empty for a bare word, which searches name, description and tags
+	Field  string
+	Op     string
+	Value  string
+	Num    int
+	Negate bool
+	//For version terms
+	Versions versionRange
+}
+
+type serverQuery []queryTerm
+
+// A query parse error, Pos is the character the problem starts at
+type QueryError struct {
+	Pos int
+	Msg string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("at character %d: %v", e.Pos+1, e.Msg)
+}
+
+// Parse a filter query
+func parseQuery(input string) (serverQuery, error) {
+	var query serverQuery
+	runes := []rune(input)
+	pos := 0
+
+	for {
+		for pos < len(runes) && unicode.IsSpace(runes[pos]) {
+			pos++
+		}
+		if pos >= len(runes) {
+			return query, nil
+		}
+
+		start := pos
+		term := queryTerm{}
+		if runes[pos] == '-' {
+			term.Negate = true
+			pos++
+		}
+
+		//field, then an operator, or it's a bare word
+		fieldEnd := pos
+		for fieldEnd < len(runes) && unicode.IsLetter(runes[fieldEnd]) {
+			fieldEnd++
+		}
+		if fieldEnd > pos && fieldEnd < len(runes) && strings.ContainsRune(":=<>", runes[fieldEnd]) {
+			name := strings.ToLower(string(runes[pos:fieldEnd]))
+			if alias, found := queryFieldAliases[name]; found {
+				name = alias
+			}
+			numeric, found := queryFields[name]
+			if !found {
+				return nil, &QueryError{pos, fmt.Sprintf("unknown field %q, use one of %v",
+					string(runes[pos:fieldEnd]), strings.Join(queryFieldNames, ", "))}
+			}
+			term.Field = name
+
+			opStart := fieldEnd
+			pos = fieldEnd + 1
+			if pos < len(runes) && runes[pos] == '=' && runes[opStart] != '=' && runes[opStart] != ':' {
+				pos++
+			}
+			term.Op = string(runes[opStart:pos])
+			//= is only different from : for text
+			if term.Op == "=" && (numeric || name == "version" || name == "password" || name == "modded") {
+				term.Op = ":"
+			}
+			if term.Op != ":" && term.Op != "=" && !numeric && name != "version" {
+				return nil, &QueryError{opStart, fmt.Sprintf("%v can't use %v, try %v:text", name, term.Op, name)}
+			}
+		}
+
+		valueStart := pos
+		value, end, err := readQueryValue(runes, pos)
+		if err != nil {
+			return nil, err
+		}
+		pos = end
+		if value == "" {
+			if term.Field != "" {
+				return nil, &QueryError{valueStart, fmt.Sprintf("missing value after %v%v", term.Field, term.Op)}
+			}
+			return nil, &QueryError{start, "missing search term after -"}
+		}
+		term.Value = strings.ToLower(value)
+
+		if err := checkQueryValue(&term); err != nil {
+			return nil, &QueryError{valueStart, err.Error()}
+		}
+		query = append(query, term)
+	}
+}
+
+// A word, or a "quoted phrase", ending at whitespace
+func readQueryValue(runes []rune, pos int) (string, int, error) {
+	if pos < len(runes) && runes[pos] == '"' {
+		for end := pos + 1; end < len(runes); end++ {
+			if runes[end] == '"' {
+				return string(runes[pos+1 : end]), end + 1, nil
+			}
+		}
+		return "", pos, &QueryError{pos, "unterminated quote"}
+	}
+
+	end := pos
+	for end < len(runes) && !unicode.IsSpace(runes[end]) {
+		end++
+	}
+	return string(runes[pos:end]), end, nil
+}
+
+func checkQueryValue(term *queryTerm) error {
+	if queryFields[term.Field] {
+		num, err := strconv.Atoi(term.Value)
+		if err != nil || num < 0 {
+			return fmt.Errorf("%v needs a whole number, got %q", term.Field, term.Value)
+		}
+		term.Num = num
+		return nil
+	}
+
+	switch term.Field {
+	case "password", "modded":
+		switch term.Value {
+		case "yes", "true", "1":
+			term.Value = "yes"
+		case "no", "false", "0":
+			term.Value = "no"
+		default:
+			return fmt.Errorf("%v must be yes or no, got %q", term.Field, term.Value)
+		}
+	case "version":
+		//version>=2.0 is the same as version:>=2.0
+		expr := term.Value
+		if term.Op != ":" {
+			expr = term.Op + expr
+			term.Op = ":"
+		}
+		versions, err := parseVersionRange(expr)
+		if err != nil {
+			return fmt.Errorf("bad version pattern %v", err)
+		}
+		term.Versions = versions
+	}
+	return nil
+}
+
+// Does the query use this field
+func (query serverQuery) has(field string) bool {
+	for _, term := range query {
+		if term.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+// Does the server match every term
+func (query serverQuery) Match(server *ServerListItem) bool {
+	for _, term := range query {
+		if term.match(server) == term.Negate {
+			return false
+		}
+	}
+	return true
+}
+
+func (term queryTerm) match(server *ServerListItem) bool {
+	switch term.Field {
+	case "":
+		return containsFold(server.Name, term.Value) ||
+			containsFold(server.Description, term.Value) ||
+			anyContainsFold(server.Tags, term.Value)
+	case "name":
+		return term.matchText([]string{server.Name})
+	case "desc":
+		return term.matchText([]string{server.Description})
+	case "tag":
+		return term.matchText(server.Tags)
+	case "player":
+		return term.matchText(server.Players)
+	case "version":
+		return term.Versions.Match(server.Application_version.Game_version)
+	case "password":
+		return server.Has_password == (term.Value == "yes")
+	case "modded":
+		return server.Local.Modded == (term.Value == "yes")
+	case "players":
+		return compareQuery(server.Local.Players, term.Op, term.Num)
+	case "mods":
+		return compareQuery(server.Mod_count, term.Op, term.Num)
+	case "minutes":
+		return compareQuery(server.Local.Minutes, term.Op, term.Num)
+	}
+	return false
+}
+
+// Does any of list contain the value, or with = equal it
+func (term queryTerm) matchText(list []string) bool {
+	if term.Op != "=" {
+		return anyContainsFold(list, term.Value)
+	}
+	for _, item := range list {
+		if strings.ToLower(strings.TrimSpace(item)) == term.Value {
+			return true
+		}
+	}
+	return false
+}
+
+func compareQuery(have int, op string, want int) bool {
+	switch op {
+	case ">":
+		return have > want
+	case ">=":
+		return have >= want
+	case "<":
+		return have < want
+	case "<=":
+		return have <= want
+	}
+	return have == want
+}
+
+// lSearch must already be lower case
+func containsFold(text, lSearch string) bool {
+	return text != "" && strings.Contains(strings.ToLower(text), lSearch)
+}
+
+func anyContainsFold(list []string, lSearch string) bool {
+	for _, item := range list {
+		if containsFold(item, lSearch) {
+			return true
+		}
+	}
+	return false
+}
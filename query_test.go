@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func queryTestServers() []ServerListItem {
+	return processServerList([]ServerListItem{
+		{Name: "Space Age PvP", Description: "big base", Host_address: "127.0.0.1:1",
+			Application_version: appVersionData{Game_version: "2.0.28"},
+			Players:             []string{"alice", "bob", "carol", "dave", "eve"},
+			Tags:                []string{"PvP", "space"}, Mod_count: 3},
+		{Name: "Space Station", Description: "whitelisted", Host_address: "127.0.0.1:2",
+			Application_version: appVersionData{Game_version: "2.0.20"},
+			Players:             []string{"frank", "grace", "heidi", "ivan", "judy", "mallory"},
+			Tags:                []string{"PvP", "whitelist"}},
+		{Name: "Old Space", Host_address: "127.0.0.1:3",
+			Application_version: appVersionData{Game_version: "1.1.110"},
+			Players:             []string{"bob"}, Tags: []string{"PvP"}, Has_password: true},
+	})
+}
+
+func queryNames(t *testing.T, input string) []string {
+	t.Helper()
+	query, err := parseQuery(input)
+	if err != nil {
+		t.Fatalf("parseQuery(%q) returned error: %v", input, err)
+	}
+	var names []string
+	for _, server := range queryTestServers() {
+		if query.Match(&server) {
+			names = append(names, server.Name)
+		}
+	}
+	return names
+}
+
+func TestQueryMatch(t *testing.T) {
+	for input, want := range map[string]string{
+		"name:space tag:pvp players>=5 version:2.0.* -tag:whitelist": "Space Age PvP",
+		"NAME:SPACE players<5":     "Old Space",
+		"mods>0":                   "Space Age PvP",
+		"password:yes":             "Old Space",
+		`desc:"big base"`:          "Space Age PvP",
+		"player:mallory":           "Space Station",
+		"whitelisted":              "Space Station",
+		"version:1.1.110":          "Old Space",
+		"player:bob -password:yes": "Space Age PvP",
+		"description:whitelisted ver:2.0.20 mod:0": "Space Station",
+		"tag=space":              "Space Age PvP",
+		"tag=white":              "",
+		`name="OLD SPACE"`:       "Old Space",
+		"password=yes players=1": "Old Space",
+	} {
+		if got := strings.Join(queryNames(t, input), ","); got != want {
+			t.Fatalf("%q: expected %q, got %q", input, want, got)
+		}
+	}
+
+	if got := queryNames(t, "   "); len(got) != 3 {
+		t.Fatalf("expected an empty query to match everything, got %v", got)
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	for input, want := range map[string]string{
+		"nmae:space":        `at character 1: unknown field "nmae"`,
+		"tag:pvp players>x": `at character 17: players needs a whole number, got "x"`,
+		"name>5":            "name can't use >",
+		"name:":             "missing value after name:",
+		`name:"space`:       "unterminated quote",
+		"password:maybe":    "password must be yes or no",
+		"version:[":         "bad version pattern",
+		"-":                 "missing search term after -",
+	} {
+		_, err := parseQuery(input)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("%q: expected error containing %q, got %v", input, want, err)
+		}
+	}
+}
+
+func TestFilterServersQueryWithAliases(t *testing.T) {
+	query, err := parseQuery("players>=5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := &ServerStateData{Servers: queryTestServers(), Searched: "PvP", FTag: true, query: query}
+	filterServers(params)
+	if params.ServersCount != 2 {
+		t.Fatalf("expected the tag alias and query to combine, got %d servers", params.ServersCount)
+	}
+
+	//Password servers stay hidden unless the query asks
+	params = &ServerStateData{Servers: queryTestServers(), FVersion: "1.1.110"}
+	filterServers(params)
+	if params.ServersCount != 0 {
+		t.Fatalf("expected password server hidden by default, got %d", params.ServersCount)
+	}
+	params.Servers, params.query = queryTestServers(), serverQuery{{Field: "password", Op: ":", Value: "yes"}}
+	filterServers(params)
+	if params.ServersCount != 1 {
+		t.Fatalf("expected password:yes to show it, got %d", params.ServersCount)
+	}
+}
+
+func TestReqHandleShowsQueryError(t *testing.T) {
+	setupDurafmt()
+	parseTemplate()
+	restore := configureFetchTestState(t)
+	defer restore()
+	publishSnapshot(newSnapshot(queryTestServers(), time.Now()))
+
+	rec := httptest.NewRecorder()
+	reqHandle(rec, httptest.NewRequest(http.MethodGet, "/?q=nmae%3Aspace", nil))
+	body := rec.Body.String()
+	if !strings.Contains(body, "Query error at character 1: unknown field") {
+		t.Fatal("expected the query error on the page")
+	}
+	if !strings.Contains(body, `value="nmae:space"`) {
+		t.Fatal("expected the query kept in the form")
+	}
+}
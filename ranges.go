@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Inclusive bounds, either can be left open
+type intRange struct {
+	Min, Max       int
+	HasMin, HasMax bool
+}
+
+func (r intRange) contains(n int) bool {
+	return (!r.HasMin || n >= r.Min) && (!r.HasMax || n <= r.Max)
+}
+
+// Apply a minplayers, maxplayers, mintime, maxtime, minmods or maxmods parameter.
+// Returns false if key isn't one of them.
+func setRangeParam(tempParams *ServerStateData, key, value string) (bool, error) {
+	var bounds *intRange
+	var text *string
+	parse := parseCount
+
+	switch strings.ToLower(key) {
+	case "minplayers", "maxplayers":
+		bounds, text = &tempParams.playerRange, &tempParams.MinPlayers
+		if strings.EqualFold(key, "maxplayers") {
+			text = &tempParams.MaxPlayers
+		}
+	case "mintime", "maxtime":
+		bounds, text, parse = &tempParams.timeRange, &tempParams.MinTime, parseHours
+		if strings.EqualFold(key, "maxtime") {
+			text = &tempParams.MaxTime
+		}
+	case "minmods", "maxmods":
+		bounds, text = &tempParams.modRange, &tempParams.MinMods
+		if strings.EqualFold(key, "maxmods") {
+			text = &tempParams.MaxMods
+		}
+	default:
+		return false, nil
+	}
+
+	//Keep what was typed, so the form stays filled in
+	value = strings.TrimSpace(value)
+	*text = value
+	if value == "" {
+		return true, nil
+	}
+	num, err := parse(value)
+	if err != nil {
+		return true, fmt.Errorf("in %v: %w", strings.ToLower(key), err)
+	}
+	if strings.HasPrefix(strings.ToLower(key), "min") {
+		bounds.Min, bounds.HasMin = num, true
+	} else {
+		bounds.Max, bounds.HasMax = num, true
+	}
+	return true, nil
+}
+
+func parseCount(value string) (int, error) {
+	num, err := strconv.Atoi(value)
+	if err != nil || num < 0 {
+		return 0, fmt.Errorf("%q isn't a whole number", value)
+	}
+	return num, nil
+}
+
+// Minutes from hours, like 10 or 1.5, or a duration like 90m or 2h30m
+func parseHours(value string) (int, error) {
+	errHours := fmt.Errorf("%q isn't a number of hours or a duration like 90m", value)
+	if hours, err := strconv.ParseFloat(value, 64); err == nil {
+		//Inf, NaN and huge numbers don't fit in the minutes
+		if math.IsInf(hours, 0) || math.IsNaN(hours) || hours < 0 || hours*60 > math.MaxInt32 {
+			return 0, errHours
+		}
+		return int(hours * 60), nil
+	}
+	//Durations top out at about 290 years, well inside MaxInt32 minutes
+	dur, err := time.ParseDuration(value)
+	if err != nil || dur < 0 {
+		return 0, errHours
+	}
+	return int(dur.Minutes()), nil
+}
+
+// Ranges with the minimum above the maximum
+func checkRanges(tempParams *ServerStateData) error {
+	for _, check := range []struct {
+		name   string
+		bounds intRange
+	}{
+		{"players", tempParams.playerRange},
+		{"time", tempParams.timeRange},
+		{"mods", tempParams.modRange},
+	} {
+		name, bounds := check.name, check.bounds
+		if bounds.HasMin && bounds.HasMax && bounds.Min > bounds.Max {
+			return fmt.Errorf("in %v: minimum is above the maximum", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseHours(t *testing.T) {
+	for value, want := range map[string]int{"10": 600, "1.5": 90, "90m": 90, "2h30m": 150, "0": 0, "35791394": 2147483640} {
+		if got, err := parseHours(value); err != nil || got != want {
+			t.Fatalf("parseHours(%q): expected %d, got %d (%v)", value, want, got, err)
+		}
+	}
+	for _, value := range []string{"-1", "soon", "-5m", "Inf", "+Inf", "NaN", "1e300", "1e400", "35791395"} {
+		if _, err := parseHours(value); err == nil || !strings.Contains(err.Error(), "isn't a number of hours") {
+			t.Fatalf("expected error for %q, got %v", value, err)
+		}
+	}
+}
+
+func TestFilterServersRanges(t *testing.T) {
+	servers := processServerList([]ServerListItem{
+		{Name: "Young", Host_address: "127.0.0.1:1", Game_time_elapsed: 120, Players: []string{"a", "b", "c"}, Mod_count: 10},
+		{Name: "Old", Host_address: "127.0.0.1:2", Game_time_elapsed: 6000, Players: []string{"a", "b", "c", "d"}},
+		{Name: "Crowded", Host_address: "127.0.0.1:3", Game_time_elapsed: 60, Players: make([]string, 30), Mod_count: 5},
+		{Name: "Heavy", Host_address: "127.0.0.1:4", Game_time_elapsed: 60, Players: []string{"a", "b", "c"}, Mod_count: 80},
+	})
+
+	//Maps younger than 10 hours with 3-20 players and fewer than 50 mods
+	params := &ServerStateData{Servers: servers}
+	for key, value := range map[string]string{"maxtime": "10", "minplayers": "3", "maxplayers": "20", "MaxMods": "49"} {
+		if found, err := setRangeParam(params, key, value); !found || err != nil {
+			t.Fatalf("setRangeParam(%q, %q): found %v, error %v", key, value, found, err)
+		}
+	}
+	if err := checkRanges(params); err != nil {
+		t.Fatal(err)
+	}
+	filterServers(params)
+	if params.ServersCount != 1 || params.Servers[0].Name != "Young" {
+		t.Fatalf("expected only Young, got %+v", params.Servers)
+	}
+
+	if found, _ := setRangeParam(params, "sort-name", ""); found {
+		t.Fatal("expected other parameters to be left alone")
+	}
+	if _, err := setRangeParam(params, "minmods", "lots"); err == nil || !strings.Contains(err.Error(), "in minmods") {
+		t.Fatalf("expected an error naming the parameter, got %v", err)
+	}
+	setRangeParam(params, "minplayers", "21")
+	if err := checkRanges(params); err == nil {
+		t.Fatal("expected error for minimum above maximum")
+	}
+}
+
+func TestReqHandleEchoesRanges(t *testing.T) {
+	setupDurafmt()
+	parseTemplate()
+	restore := configureFetchTestState(t)
+	defer restore()
+	publishSnapshot(newSnapshot(queryTestServers(), time.Now()))
+
+	rec := httptest.NewRecorder()
+	reqHandle(rec, httptest.NewRequest(http.MethodGet, "/?minplayers=6&maxtime=90m&minmods=x", nil))
+	body := rec.Body.String()
+	for _, want := range []string{`id="minplayers" value="6"`, `id="maxtime" value="90m"`, `id="minmods" value="x"`,
+		"Query error in minmods:", "Space Station"} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected %q in the page", want)
+		}
+	}
+	if strings.Contains(body, "Space Age PvP") {
+		t.Fatal("expected servers under 6 players filtered out")
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Rich text token kinds
+const (
+	RT_TEXT = iota
+	RT_NEWLINE
+	RT_OPEN
+	RT_CLOSE
+	RT_ICON
+)
+
+// A piece of Factorio rich text
+type richToken struct {
+	Kind  int
+	Text  string
+	Name  string
+	Value string
+}
+
+// Named colors Factorio understands
+var richTextColors = map[string]string{
+	"default": "#e0e0e0",
+	"red":     "#ff0000",
+	"green":   "#00ff00",
+	"blue":    "#0000ff",
+	"orange":  "#ff7f00",
+	"yellow":  "#ffff00",
+	"pink":    "#ff69b4",
+	"purple":  "#b266ff",
+	"white":   "#ffffff",
+	"black":   "#000000",
+	"gray":    "#808080",
+	"brown":   "#8b4513",
+	"cyan":    "#00ffff",
+	"acid":    "#a5ff00",
+}
+
+var (
+	richTagName  = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+	richHexColor = regexp.MustCompile(`^#?([0-9a-fA-F]{6})([0-9a-fA-F]{2})?$`)
+)
+
+// Split rich text into text, newlines and tags.
+// Anything in brackets that isn't a tag is left as text.
+func tokenizeRichText(input string) []richToken {
+	var tokens []richToken
+	var text strings.Builder
+
+	flush := func() {
+		if text.Len() > 0 {
+			tokens = append(tokens, richToken{Kind: RT_TEXT, Text: text.String()})
+			text.Reset()
+		}
+	}
+
+	for i := 0; i < len(input); {
+		switch input[i] {
+		case '\r':
+			flush()
+			tokens = append(tokens, richToken{Kind: RT_NEWLINE})
+			//\r\n and \n\r are one newline
+			if i+1 < len(input) && input[i+1] == '\n' {
+				i++
+			}
+			i++
+			continue
+		case '\n':
+			flush()
+			tokens = append(tokens, richToken{Kind: RT_NEWLINE})
+			if i+1 < len(input) && input[i+1] == '\r' {
+				i++
+			}
+			i++
+			continue
+		case '[':
+			end := strings.IndexByte(input[i:], ']')
+			if end > 0 {
+				if tok, ok := parseRichTag(input[i+1 : i+end]); ok {
+					flush()
+					tokens = append(tokens, tok)
+					i += end + 1
+					continue
+				}
+			}
+		}
+		text.WriteByte(input[i])
+		i++
+	}
+	flush()
+	return tokens
+}
+
+// Parse the inside of [name=value], [/name] or [.name]
+func parseRichTag(inner string) (richToken, bool) {
+	if strings.HasPrefix(inner, "/") || strings.HasPrefix(inner, ".") {
+		name := strings.ToLower(inner[1:])
+		if !richTagName.MatchString(name) {
+			return richToken{}, false
+		}
+		return richToken{Kind: RT_CLOSE, Name: name}, true
+	}
+
+	name, value, found := strings.Cut(inner, "=")
+	name = strings.ToLower(strings.TrimSpace(name))
+	if !found || !richTagName.MatchString(name) {
+		return richToken{}, false
+	}
+	value = strings.TrimSpace(value)
+
+	if name == "color" || name == "font" {
+		return richToken{Kind: RT_OPEN, Name: name, Value: value}, true
+	}
+	return richToken{Kind: RT_ICON, Name: name, Value: value}, true
+}
+
+// Safe HTML: colored and font spans, icon placeholders and line breaks
+func RichTextHTML(input string) template.HTML {
+	return RichTextHTMLMark(input, 0, 0)
+}
+
+// RichTextHTML, with runes start to end of richPlainText(input) in <mark>
+func RichTextHTMLMark(input string, start, end int) template.HTML {
+	var buf strings.Builder
+	var open []string
+	pos := 0
+
+	for _, tok := range tokenizeRichText(input) {
+		switch tok.Kind {
+		case RT_TEXT:
+			//Marks open and close inside the text, so they nest
+			runes := []rune(tok.Text)
+			from := min(max(start-pos, 0), len(runes))
+			to := min(max(end-pos, from), len(runes))
+			buf.WriteString(html.EscapeString(string(runes[:from])))
+			if to > from {
+				buf.WriteString("<mark>" + html.EscapeString(string(runes[from:to])) + "</mark>")
+			}
+			buf.WriteString(html.EscapeString(string(runes[to:])))
+			pos += len(runes)
+		case RT_NEWLINE:
+			buf.WriteString("<br>")
+			pos++
+		case RT_OPEN:
+			buf.WriteString(`<span style="` + richTextStyle(tok) + `">`)
+			open = append(open, tok.Name)
+		case RT_CLOSE:
+			//Only close if it matches the innermost open tag
+			if len(open) > 0 && open[len(open)-1] == tok.Name {
+				buf.WriteString("</span>")
+				open = open[:len(open)-1]
+			}
+		case RT_ICON:
+			buf.WriteString(`<span class="rt-icon" title="` + html.EscapeString(tok.Name+": "+tok.Value) + `">`)
+			buf.WriteString(html.EscapeString(richIconText(tok)))
+			buf.WriteString("</span>")
+		}
+	}
+	for range open {
+		buf.WriteString("</span>")
+	}
+
+	return template.HTML(buf.String())
+}
+
+// Plain text for searching and sorting, tags removed and newlines collapsed
+func RemoveFactorioTags(input string) string {
+	var buf strings.Builder
+	lastNewline := false
+
+	for _, tok := range tokenizeRichText(input) {
+		switch tok.Kind {
+		case RT_TEXT:
+			buf.WriteString(tok.Text)
+			lastNewline = false
+		case RT_NEWLINE:
+			if !lastNewline {
+				buf.WriteString("  ")
+			}
+			lastNewline = true
+		}
+	}
+	return buf.String()
+}
+
+// Text only, one space per newline, for matching against RichTextHTMLMark
+func richPlainText(input string) string {
+	var buf strings.Builder
+	for _, tok := range tokenizeRichText(input) {
+		switch tok.Kind {
+		case RT_TEXT:
+			buf.WriteString(tok.Text)
+		case RT_NEWLINE:
+			buf.WriteByte(' ')
+		}
+	}
+	return buf.String()
+}
+
+// CSS for a color or font tag, only from values we understand
+func richTextStyle(tok richToken) string {
+	if tok.Name == "font" {
+		var style []string
+		font := strings.ToLower(tok.Value)
+		if strings.Contains(font, "bold") || strings.Contains(font, "semibold") {
+			style = append(style, "font-weight:bold")
+		}
+		if strings.Contains(font, "large") || strings.Contains(font, "heading") {
+			style = append(style, "font-size:1.2em")
+		} else if strings.Contains(font, "small") {
+			style = append(style, "font-size:0.85em")
+		}
+		if strings.Contains(font, "mono") {
+			style = append(style, "font-family:monospace")
+		}
+		return strings.Join(style, ";")
+	}
+
+	if color, ok := parseRichColor(tok.Value); ok {
+		return "color:" + color
+	}
+	return ""
+}
+
+// Named, hex or r,g,b (0-1 or 0-255) colors, as #rrggbb
+func parseRichColor(value string) (string, bool) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if color, ok := richTextColors[value]; ok {
+		return color, true
+	}
+	if match := richHexColor.FindStringSubmatch(value); match != nil {
+		return "#" + match[1], true
+	}
+
+	parts := strings.Split(value, ",")
+	if len(parts) != 3 && len(parts) != 4 {
+		return "", false
+	}
+	var rgb [3]float64
+	scale := 1.0
+	for i := range rgb {
+		num, err := strconv.ParseFloat(strings.TrimSpace(parts[i]), 64)
+		if err != nil || num < 0 || num > 255 {
+			return "", false
+		}
+		rgb[i] = num
+		if num > 1 {
+			scale = 255
+		}
+	}
+	return fmt.Sprintf("#%02x%02x%02x",
+		int(rgb[0]/scale*255+0.5), int(rgb[1]/scale*255+0.5), int(rgb[2]/scale*255+0.5)), true
+}
+
+// Readable stand-in for an icon, like [iron plate]
+func richIconText(tok richToken) string {
+	value := tok.Value
+	if tok.Name == "img" {
+		//img=item/iron-plate
+		if _, after, found := strings.Cut(value, "/"); found {
+			value = after
+		}
+	}
+	if tok.Name == "gps" {
+		return "[gps " + value + "]"
+	}
+	//Drop quality and other extra arguments
+	value, _, _ = strings.Cut(value, ",")
+	return "[" + strings.ReplaceAll(value, "-", " ") + "]"
+}
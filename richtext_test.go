@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRichTextHTML(t *testing.T) {
+	cases := map[string]string{
+		"[color=red]Red[/color] text":           `<span style="color:#ff0000">Red</span> text`,
+		"[color=1,0.5,0]Or[.color]":             `<span style="color:#ff8000">Or</span>`,
+		"[color=#00ff00]G[/color]":              `<span style="color:#00ff00">G</span>`,
+		"[font=default-bold]B[/font]":           `<span style="font-weight:bold">B</span>`,
+		"Line one\r\nLine two":                  `Line one<br>Line two`,
+		"[item=iron-plate] x5":                  `<span class="rt-icon" title="item: iron-plate">[iron plate]</span> x5`,
+		"[img=entity/small-biter]":              `<span class="rt-icon" title="img: entity/small-biter">[small biter]</span>`,
+		"[color=red]unclosed":                   `<span style="color:#ff0000">unclosed</span>`,
+		"stray [/color] closer":                 `stray  closer`,
+		"[EU] Clan":                             `[EU] Clan`,
+		"<script>alert(1)</script>":             `&lt;script&gt;alert(1)&lt;/script&gt;`,
+		`[color=red" onmouseover="x]hi[/color]`: `<span style="">hi</span>`,
+	}
+	for input, want := range cases {
+		if got := string(RichTextHTML(input)); got != want {
+			t.Fatalf("RichTextHTML(%q)\n got %q\nwant %q", input, got, want)
+		}
+	}
+}
+
+func TestRichTextHTMLMark(t *testing.T) {
+	input := "[color=red]Space[/color] Age\nPvP"
+	if plain := richPlainText(input); plain != "Space Age PvP" {
+		t.Fatalf("unexpected plain text %q", plain)
+	}
+	//"ce Ag" crosses the closing tag
+	want := `<span style="color:#ff0000">Spa<mark>ce</mark></span><mark> Ag</mark>e<br>PvP`
+	if got := string(RichTextHTMLMark(input, 3, 8)); got != want {
+		t.Fatalf("RichTextHTMLMark\n got %q\nwant %q", got, want)
+	}
+}
+
+func TestRemoveFactorioTags(t *testing.T) {
+	got := RemoveFactorioTags("[color=red]Server[/color] [item=iron-plate]\n\nNew [EU]")
+	if got != "Server   New [EU]" {
+		t.Fatalf("unexpected plain text %q", got)
+	}
+}
+
+func TestProcessServerListResetsLocalHTML(t *testing.T) {
+	setupDurafmt()
+
+	servers := seedServers(1)
+	servers[0].Name = "[color=blue]Blue[/color]"
+	servers[0].Local.NameHTML = "<script>"
+	list := processServerList(servers)
+
+	if list[0].Name != "Blue" {
+		t.Fatalf("expected plain name, got %q", list[0].Name)
+	}
+	if strings.Contains(string(list[0].Local.NameHTML), "script") ||
+		!strings.Contains(string(list[0].Local.NameHTML), "color:#0000ff") {
+		t.Fatalf("unexpected name HTML %q", list[0].Local.NameHTML)
+	}
+}
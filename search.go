@@ -0,0 +1,146 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// How much a word counts, by where it was found
+const (
+	SearchNameWeight = 3
+	SearchTagWeight  = 2
+	SearchDescWeight = 1
+)
+
+// Inverted index of words in server names, tags and descriptions.
+// Built with the snapshot, server numbers are positions in Snapshot.Servers.
+type searchIndex struct {
+	postings map[string][]searchPosting
+	//Sorted, for prefix lookups
+	words []string
+}
+
+type searchPosting struct {
+	Server, Weight int
+}
+
+// Accented letters, and the plain letters we search them as
+var searchFoldTable = buildFoldTable()
+
+func buildFoldTable() map[rune]string {
+	table := map[rune]string{}
+	for plain, accented := range map[string]string{
+		"a": "àáâãäåāăą", "c": "çćĉċč", "d": "ďđð", "e": "èéêëēĕėęě",
+		"g": "ĝğġģ", "h": "ĥħ", "i": "ìíîïĩīĭįı", "j": "ĵ", "k": "ķ",
+		"l": "ĺļľŀł", "n": "ñńņňŉ", "o": "òóôõöøōŏő", "r": "ŕŗř",
+		"s": "śŝşšș", "t": "ţťŧț", "u": "ùúûüũūŭůűų", "w": "ŵ",
+		"y": "ýÿŷ", "z": "źżž", "ss": "ß", "ae": "æ", "oe": "œ", "th": "þ",
+	} {
+		for _, r := range accented {
+			table[r] = plain
+		}
+	}
+	return table
+}
+
+// Lower case, accent folded words, rich text tags removed
+func searchWords(text string) []string {
+	var words []string
+	var word strings.Builder
+
+	for _, r := range RemoveFactorioTags(text) {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			if word.Len() > 0 {
+				words = append(words, word.String())
+				word.Reset()
+			}
+			continue
+		}
+		r = unicode.ToLower(r)
+		if plain, found := searchFoldTable[r]; found {
+			word.WriteString(plain)
+		} else {
+			word.WriteRune(r)
+		}
+	}
+	if word.Len() > 0 {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+func buildSearchIndex(servers []ServerListItem) *searchIndex {
+	index := &searchIndex{postings: map[string][]searchPosting{}}
+
+	for i, server := range servers {
+		//Each word counts once per field
+		weights := map[string]int{}
+		addField := func(text string, weight int, seen map[string]bool) {
+			for _, word := range searchWords(text) {
+				if !seen[word] {
+					seen[word] = true
+					weights[word] += weight
+				}
+			}
+		}
+		addField(server.Name, SearchNameWeight, map[string]bool{})
+		addField(server.Description, SearchDescWeight, map[string]bool{})
+		tagSeen := map[string]bool{}
+		for _, tag := range server.Tags {
+			addField(tag, SearchTagWeight, tagSeen)
+		}
+
+		for word, weight := range weights {
+			index.postings[word] = append(index.postings[word], searchPosting{Server: i, Weight: weight})
+		}
+	}
+
+	index.words = make([]string, 0, len(index.postings))
+	for word := range index.postings {
+		index.words = append(index.words, word)
+	}
+	sort.Strings(index.words)
+	return index
+}
+
+// Score servers that have every word of text, exact words count double a prefix.
+// nil if text has no words.
+func (index *searchIndex) search(text string) map[int]int {
+	words := searchWords(text)
+	if index == nil || len(words) == 0 {
+		return nil
+	}
+
+	var scores map[int]int
+	for _, word := range words {
+		best := map[int]int{}
+		for i := sort.SearchStrings(index.words, word); i < len(index.words); i++ {
+			indexed := index.words[i]
+			if !strings.HasPrefix(indexed, word) {
+				break
+			}
+			scale := 1
+			if indexed == word {
+				scale = 2
+			}
+			for _, post := range index.postings[indexed] {
+				best[post.Server] = max(best[post.Server], post.Weight*scale)
+			}
+		}
+
+		//Only servers that matched every word so far
+		if scores == nil {
+			scores = best
+			continue
+		}
+		for server, score := range scores {
+			if best[server] == 0 {
+				delete(scores, server)
+			} else {
+				scores[server] = score + best[server]
+			}
+		}
+	}
+	return scores
+}
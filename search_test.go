@@ -0,0 +1,74 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSearchWords(t *testing.T) {
+	got := searchWords("[color=red]Café Überfluß[/color], Ørsted-2.0 ŁÓDŹ")
+	want := []string{"cafe", "uberfluss", "orsted", "2", "0", "lodz"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func searchTestServers() []ServerListItem {
+	return processServerList([]ServerListItem{
+		{Name: "Castle Builders", Description: "bring a rail", Host_address: "127.0.0.1:1",
+			Tags: []string{"pvp"}, Players: []string{"a"}},
+		{Name: "Rail World", Description: "trains everywhere", Host_address: "127.0.0.1:2",
+			Tags: []string{"castle"}},
+		{Name: "Megabase", Description: "a castle on a hill", Host_address: "127.0.0.1:3",
+			Tags: []string{"railway"}},
+		{Name: "Crème Brûlée", Host_address: "127.0.0.1:4"},
+	})
+}
+
+func TestSearchIndexWeights(t *testing.T) {
+	index := buildSearchIndex(searchTestServers())
+
+	//Name beats tag beats description
+	scores := index.search("castle")
+	if len(scores) != 3 || !(scores[0] > scores[1] && scores[1] > scores[2]) {
+		t.Fatalf("expected name > tag > description, got %v", scores)
+	}
+
+	//Every word has to match, prefixes count less than whole words
+	scores = index.search("castle rail")
+	if len(scores) != 3 {
+		t.Fatalf("expected 3 servers with both words, got %v", scores)
+	}
+	if scores[1] <= scores[2] {
+		t.Fatalf("expected whole word rail to beat prefix of railway, got %v", scores)
+	}
+	if scores := index.search("castle trains"); len(scores) != 1 || scores[1] == 0 {
+		t.Fatalf("expected only Rail World, got %v", scores)
+	}
+
+	if scores := index.search("creme brulee"); len(scores) != 1 || scores[3] == 0 {
+		t.Fatalf("expected accents to fold, got %v", scores)
+	}
+	if index.search(" ,. ") != nil {
+		t.Fatal("expected nil scores for a search without words")
+	}
+}
+
+func TestFilterServersRelevanceSort(t *testing.T) {
+	snap := newSnapshot(searchTestServers(), getSnapshot().FetchedAt)
+	params := &ServerStateData{Servers: snap.Servers, index: snap.Index, Searched: "Castle", FText: true}
+	filterServers(params)
+	servers := sortServers(false, params.Servers, SORT_RELEVANCE)
+
+	var names []string
+	for _, server := range servers {
+		names = append(names, server.Name)
+	}
+	want := []string{"Castle Builders", "Rail World", "Megabase"}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("expected %v, got %v", want, names)
+	}
+	if snap.Servers[0].Local.Relevance != 0 {
+		t.Fatal("expected the snapshot to be left alone")
+	}
+}
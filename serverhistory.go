@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"goFactServView/cwlog"
+	"html/template"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	//How much player history each server keeps
+	SparklineWindow = 24 * time.Hour
+	//Sparkline size, in pixels
+	SparklineWidth  = 80
+	SparklineHeight = 16
+)
+
+var (
+	//Protects playerCounts
+	PlayerCountLock sync.RWMutex
+	//Recent player counts, by serverKey
+	playerCounts = map[string][]playerSample{}
+)
+
+type playerSample struct {
+	At      time.Time
+	Players int
+}
+
+// Identifies a server across fetches, game ids change on restart
+func serverKey(item ServerListItem) string {
+	return item.Host_address + "|" + item.Name
+}
+
+// Add a sample for every server, and forget samples past the window
+func recordPlayerCounts(servers []ServerListItem, at time.Time) {
+	PlayerCountLock.Lock()
+	defer PlayerCountLock.Unlock()
+
+	for _, item := range servers {
+		key := serverKey(item)
+		playerCounts[key] = append(playerCounts[key], playerSample{At: at, Players: len(item.Players)})
+	}
+
+	cutoff := at.Add(-SparklineWindow)
+	for key, samples := range playerCounts {
+		start := 0
+		for start < len(samples) && samples[start].At.Before(cutoff) {
+			start++
+		}
+		if start == len(samples) {
+			delete(playerCounts, key)
+		} else if start > 0 {
+			playerCounts[key] = append([]playerSample(nil), samples[start:]...)
+		}
+	}
+}
+
+// Refill player counts from stored history, after a restart
+func loadPlayerCounts(now time.Time) {
+	loaded := 0
+	for _, when := range historyTimes() {
+		if now.Sub(when) > SparklineWindow {
+			continue
+		}
+		entry, err := historyAt(when)
+		if err != nil {
+			cwlog.DoLog(true, "loadPlayerCounts: %v", err)
+			continue
+		}
+		recordPlayerCounts(entry.Servers, entry.FetchedAt)
+		loaded++
+	}
+	if loaded > 0 {
+		cwlog.DoLog(false, "Loaded player counts from %v snapshots.", loaded)
+	}
+}
+
+// Recent player counts for one server, oldest first
+func serverPlayerCounts(item ServerListItem) []playerSample {
+	PlayerCountLock.RLock()
+	defer PlayerCountLock.RUnlock()
+
+	return append([]playerSample(nil), playerCounts[serverKey(item)]...)
+}
+
+// Template helper: inline SVG of a server's recent player counts
+func serverSparkline(item ServerListItem) template.HTML {
+	return sparklineSVG(serverPlayerCounts(item), time.Now())
+}
+
+// Small SVG line of samples over the last SparklineWindow, empty if too few
+func sparklineSVG(samples []playerSample, now time.Time) template.HTML {
+	if len(samples) < 2 {
+		return ""
+	}
+
+	peak := 0
+	for _, sample := range samples {
+		peak = max(peak, sample.Players)
+	}
+
+	start := now.Add(-SparklineWindow)
+	points := make([]string, 0, len(samples))
+	for _, sample := range samples {
+		x := float64(sample.At.Sub(start)) / float64(SparklineWindow) * SparklineWidth
+		x = min(max(x, 0), SparklineWidth)
+		//Leave a pixel top and bottom so the line isn't clipped
+		y := float64(SparklineHeight - 1)
+		if peak > 0 {
+			y -= float64(sample.Players) / float64(peak) * (SparklineHeight - 2)
+		}
+		points = append(points, fmt.Sprintf("%.1f,%.1f", x, y))
+	}
+
+	return template.HTML(fmt.Sprintf(
+		`<svg class="sparkline" width="%d" height="%d" viewBox="0 0 %d %d" role="img" aria-label="Players, last 24 hours, peak %d">`+
+			`<title>Last 24 hours, peak %d</title>`+
+			`<polyline fill="none" stroke="currentColor" stroke-width="1" points="%s"/></svg>`,
+		SparklineWidth, SparklineHeight, SparklineWidth, SparklineHeight, peak, peak, strings.Join(points, " ")))
+}
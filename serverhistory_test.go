@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordPlayerCountsTrimsWindow(t *testing.T) {
+	PlayerCountLock.Lock()
+	oldCounts := playerCounts
+	playerCounts = map[string][]playerSample{}
+	PlayerCountLock.Unlock()
+	defer func() { playerCounts = oldCounts }()
+
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	servers := seedServers(2)
+	servers[0].Players = []string{"a", "b"}
+
+	recordPlayerCounts(servers, now.Add(-25*time.Hour))
+	recordPlayerCounts(servers[:1], now.Add(-time.Hour))
+	recordPlayerCounts(servers[:1], now)
+
+	samples := serverPlayerCounts(servers[0])
+	if len(samples) != 2 {
+		t.Fatalf("expected 2 samples in window, got %d", len(samples))
+	}
+	if samples[1].Players != 2 {
+		t.Fatalf("expected 2 players, got %d", samples[1].Players)
+	}
+	if len(serverPlayerCounts(servers[1])) != 0 {
+		t.Fatal("expected server gone for a day to be forgotten")
+	}
+}
+
+func TestSparklineSVG(t *testing.T) {
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	if got := sparklineSVG([]playerSample{{At: now, Players: 3}}, now); got != "" {
+		t.Fatalf("expected no sparkline for one sample, got %q", got)
+	}
+
+	svg := string(sparklineSVG([]playerSample{
+		{At: now.Add(-SparklineWindow), Players: 0},
+		{At: now.Add(-SparklineWindow / 2), Players: 10},
+		{At: now, Players: 5},
+	}, now))
+	if !strings.HasPrefix(svg, "<svg") || !strings.Contains(svg, "peak 10") {
+		t.Fatalf("unexpected sparkline: %q", svg)
+	}
+	want := `points="0.0,15.0 40.0,1.0 80.0,8.0"`
+	if !strings.Contains(svg, want) {
+		t.Fatalf("expected %s in %q", want, svg)
+	}
+}
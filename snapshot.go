@@ -0,0 +1,40 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// The list readers see, replaced whole on each fetch
+var currentSnapshot atomic.Pointer[Snapshot]
+
+// Build a snapshot from a processed list
+func newSnapshot(servers []ServerListItem, fetchedAt time.Time) *Snapshot {
+	totalPlayers := 0
+	for _, item := range servers {
+		totalPlayers = totalPlayers + len(item.Players)
+	}
+
+	versionList := getVersions(servers)
+	return &Snapshot{
+		Servers:       servers,
+		VersionList:   versionList,
+		VersionGroups: groupVersions(versionList),
+		ServersCount:  len(servers),
+		PlayerCount:   totalPlayers,
+		FetchedAt:     fetchedAt,
+		Index:         buildSearchIndex(servers),
+	}
+}
+
+// Current snapshot, never nil. Must not be modified.
+func getSnapshot() *Snapshot {
+	if snap := currentSnapshot.Load(); snap != nil {
+		return snap
+	}
+	return &Snapshot{}
+}
+
+func publishSnapshot(snap *Snapshot) {
+	currentSnapshot.Store(snap)
+}
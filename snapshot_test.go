@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Run with -race: readers must never see a half-written list
+func TestSnapshotConcurrentFetchAndRequests(t *testing.T) {
+	setupDurafmt()
+	parseTemplate()
+
+	restore := configureFetchTestState(t)
+	defer restore()
+
+	serverSource = &replaySource{Frames: [][]byte{
+		[]byte(makeServerListJSON(MinValidCount + 1)),
+		[]byte(makeServerListJSON(MinValidCount + 30)),
+	}}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			RefreshLock.Lock()
+			markStale()
+			fetchServerList()
+			RefreshLock.Unlock()
+		}
+	}()
+
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				rec := httptest.NewRecorder()
+				reqHandle(rec, httptest.NewRequest(http.MethodGet, "/?sort-name&page=2&anypass", nil))
+				if rec.Code != http.StatusOK {
+					t.Errorf("unexpected status %d", rec.Code)
+				}
+
+				snap := getSnapshot()
+				if snap.ServersCount != len(snap.Servers) {
+					t.Errorf("snapshot count %d doesn't match %d servers", snap.ServersCount, len(snap.Servers))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	//Let any background refresh finish before restoring state
+	deadline := time.Now().Add(5 * time.Second)
+	for refreshing.Load() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+}
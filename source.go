@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Somewhere a raw server list can be fetched from
+type ServerSource interface {
+	Name() string
+	Fetch() ([]ServerListItem, error)
+}
+
+// Where fetchServerList gets data from
+var serverSource ServerSource = &httpSource{}
+
+// Sources that can skip unchanged lists
+type conditionalSource interface {
+	ServerSource
+	//Validators from the last full response
+	Validators() (etag, lastModified string)
+}
+
+// Sources passing on a list fetched earlier, by someone else
+type datedSource interface {
+	ServerSource
+	//When the last list was fetched from matchmaking
+	FetchedAt() time.Time
+}
+
+// Returned by a conditionalSource when the list hasn't changed
+var errNotModified = errors.New("server list not modified")
+
+// Matchmaking server get-games, uses upstream URL/username/token.
+// Replays the cached ETag/Last-Modified, and asks for gzip.
+type httpSource struct {
+	etag, lastModified string
+}
+
+func (src *httpSource) Name() string {
+	return "matchmaking"
+}
+
+func (src *httpSource) Fetch() ([]ServerListItem, error) {
+
+	//Build query
+	params := url.Values{}
+	params.Add("username", *upstream.Username)
+	params.Add("token", *upstream.Token)
+	urlBuf := buildFetchURL(*upstream.URL, params)
+
+	//HTTP GET
+	req, err := http.NewRequest(http.MethodGet, urlBuf, nil)
+	if err != nil {
+		return nil, fmt.Errorf("request build failed: %w", err)
+	}
+
+	req.Header.Set("User-Agent", UserAgent)
+	//Setting this ourselves turns off transparent decompression
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	//Only conditional if our list came from here
+	if snap := getSnapshot(); !snap.Mirrored {
+		if snap.ETag != "" {
+			req.Header.Set("If-None-Match", snap.ETag)
+		}
+		if snap.LastModified != "" {
+			req.Header.Set("If-Modified-Since", snap.LastModified)
+		}
+	}
+
+	//Get response
+	res, getErr := fetchHTTPClient().Do(req)
+	if getErr != nil {
+		return nil, fmt.Errorf("request failed: %w", getErr)
+	}
+
+	//Close once complete, if valid
+	if res.Body != nil {
+		defer res.Body.Close()
+	}
+
+	if res.StatusCode == http.StatusNotModified {
+		return nil, errNotModified
+	}
+	if res.StatusCode != http.StatusOK {
+		errBody, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		return nil, &upstreamStatusError{Code: res.StatusCode, Body: shortenBody(errBody)}
+	}
+
+	//Only a full list is worth decompressing
+	body := io.Reader(res.Body)
+	if strings.EqualFold(res.Header.Get("Content-Encoding"), "gzip") {
+		zr, err := gzip.NewReader(res.Body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip: %w", err)
+		}
+		defer zr.Close()
+		body = zr
+	}
+
+	newServerList, err := decodeServerList(body)
+	if err != nil {
+		return nil, err
+	}
+	src.etag = res.Header.Get("ETag")
+	src.lastModified = res.Header.Get("Last-Modified")
+	return newServerList, nil
+}
+
+func (src *httpSource) Validators() (string, string) {
+	return src.etag, src.lastModified
+}
+
+// Local JSON file, either a get-games response or a cache file
+type fileSource struct {
+	Path string
+}
+
+func (src *fileSource) Name() string {
+	return "file " + src.Path
+}
+
+func (src *fileSource) Fetch() ([]ServerListItem, error) {
+	file, err := os.Open(src.Path)
+	if err != nil {
+		return nil, fmt.Errorf("read failed: %w", err)
+	}
+	defer file.Close()
+
+	return decodeServerList(file)
+}
+
+// Steps through recorded responses, looping after the last one
+type replaySource struct {
+	Names  []string
+	Frames [][]byte
+	pos    int
+}
+
+// Load every .json file in dir, in name order
+func newReplaySource(dir string) (*replaySource, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no recorded responses (*.json) in %v", dir)
+	}
+	sort.Strings(names)
+
+	src := &replaySource{}
+	for _, name := range names {
+		body, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		src.Names = append(src.Names, name)
+		src.Frames = append(src.Frames, body)
+	}
+	return src, nil
+}
+
+func (src *replaySource) Name() string {
+	return "replay"
+}
+
+func (src *replaySource) Fetch() ([]ServerListItem, error) {
+	if len(src.Frames) == 0 {
+		return nil, fmt.Errorf("no recorded responses")
+	}
+	frame := src.pos % len(src.Frames)
+	src.pos++
+
+	list, err := decodeServerList(bytes.NewReader(src.Frames[frame]))
+	if err != nil && frame < len(src.Names) {
+		return nil, fmt.Errorf("%v: %w", src.Names[frame], err)
+	}
+	return list, err
+}
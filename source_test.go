@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReplaySourceStepsThroughFrames(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "01.json"), makeServerListJSON(MinValidCount+1))
+	writeTestFile(t, filepath.Join(dir, "02.json"), makeServerListJSON(MinValidCount+2))
+
+	src, err := newReplaySource(dir)
+	if err != nil {
+		t.Fatalf("newReplaySource returned error: %v", err)
+	}
+
+	for _, want := range []int{MinValidCount + 1, MinValidCount + 2, MinValidCount + 1} {
+		list, err := src.Fetch()
+		if err != nil {
+			t.Fatalf("Fetch returned error: %v", err)
+		}
+		if len(list) != want {
+			t.Fatalf("expected %d servers, got %d", want, len(list))
+		}
+	}
+}
+
+func TestReplaySourceRequiresFrames(t *testing.T) {
+	if _, err := newReplaySource(t.TempDir()); err == nil {
+		t.Fatal("expected error for empty replay directory")
+	}
+}
+
+func TestFileSourceReadsCacheFormat(t *testing.T) {
+	setupDurafmt()
+
+	servers := processServerList(seedServers(MinValidCount + 1))
+	body, err := json.Marshal(CacheData{Version: CacheVersion, Servers: servers})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "cache.json")
+	writeTestFile(t, path, string(body))
+
+	list, err := (&fileSource{Path: path}).Fetch()
+	if err != nil {
+		t.Fatalf("Fetch returned error: %v", err)
+	}
+	if len(list) != MinValidCount+1 {
+		t.Fatalf("expected %d servers, got %d", MinValidCount+1, len(list))
+	}
+}
+
+func TestFetchServerListFromReplaySource(t *testing.T) {
+	setupDurafmt()
+
+	restore := configureFetchTestState(t)
+	defer restore()
+
+	serverSource = &replaySource{Frames: [][]byte{
+		[]byte(makeServerListJSON(MinValidCount + 3)),
+		[]byte("{not-json"),
+	}}
+
+	if err := fetchServerList(); err != nil {
+		t.Fatalf("fetchServerList returned error: %v", err)
+	}
+	if getSnapshot().ServersCount != MinValidCount+3 {
+		t.Fatalf("expected %d servers, got %d", MinValidCount+3, getSnapshot().ServersCount)
+	}
+
+	markStale()
+	if err := fetchServerList(); err == nil {
+		t.Fatal("expected error from invalid frame")
+	}
+	if getSnapshot().ServersCount != MinValidCount+3 {
+		t.Fatalf("expected servers to remain, got %d", getSnapshot().ServersCount)
+	}
+}
+
+func writeTestFile(t *testing.T, path, body string) {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"goFactServView/cwlog"
+	"html"
+	"html/template"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	//Most points drawn per chart, more are averaged together
+	MaxChartPoints = 240
+	//Versions drawn on the adoption chart, the rest are "other"
+	MaxChartVersions = 6
+
+	//Chart size, in SVG units
+	chartWidth  = 800
+	chartHeight = 220
+	chartLeft   = 50
+	chartRight  = 10
+	chartTop    = 10
+	chartBottom = 25
+)
+
+var (
+	statsFile = "data/stats.jsonl"
+
+	//Protects statsPoints and statsFile
+	StatsLock sync.RWMutex
+	//Totals from each successful fetch, oldest first
+	statsPoints []StatsPoint
+
+	chartColors = []string{"#e67e22", "#3498db", "#2ecc71", "#e74c3c", "#9b59b6", "#f1c40f", "#95a5a6"}
+
+	//Selectable ranges on the stats page, first is the default
+	statsRanges = []statsRange{
+		{Name: "week", Span: 7 * 24 * time.Hour},
+		{Name: "day", Span: 24 * time.Hour},
+		{Name: "month", Span: 30 * 24 * time.Hour},
+		{Name: "year", Span: 365 * 24 * time.Hour},
+		{Name: "all"},
+	}
+)
+
+// Totals at one fetch
+type StatsPoint struct {
+	At      time.Time
+	Players int
+	Servers int
+	Modded  int
+	//Server counts by major.minor version
+	Versions map[string]int `json:",omitempty"`
+}
+
+type statsRange struct {
+	Name string
+	//Zero means everything
+	Span time.Duration
+}
+
+type StatsPage struct {
+	Range   string
+	Ranges  []string
+	Points  int
+	Latest  StatsPoint
+	Charts  []StatsChart
+	Enabled bool
+}
+
+type StatsChart struct {
+	Title string
+	SVG   template.HTML
+}
+
+type chartSeries struct {
+	Name   string
+	Values []float64
+}
+
+// Totals for a snapshot
+func snapshotStats(snap *Snapshot) StatsPoint {
+	point := StatsPoint{
+		At:       snap.FetchedAt.UTC(),
+		Players:  snap.PlayerCount,
+		Servers:  snap.ServersCount,
+		Versions: map[string]int{},
+	}
+	for _, item := range snap.Servers {
+		if item.Local.Modded {
+			point.Modded++
+		}
+		point.Versions[minorVersion(item.Application_version.Game_version)]++
+	}
+	return point
+}
+
+// "2.0.28" to "2.0"
+func minorVersion(version string) string {
+	ver := parseVersion(version)
+	return fmt.Sprintf("%d.%d", ver.a, ver.b)
+}
+
+// Save totals from a successful fetch
+func recordStats(snap *Snapshot) {
+	if len(historyRetention) == 0 {
+		return
+	}
+	point := snapshotStats(snap)
+
+	StatsLock.Lock()
+	defer StatsLock.Unlock()
+
+	statsPoints = append(statsPoints, point)
+
+	line, err := json.Marshal(point)
+	if err != nil {
+		cwlog.DoLog(true, "recordStats: %v", err)
+		return
+	}
+	file, err := os.OpenFile(statsFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		cwlog.DoLog(true, "recordStats: %v", err)
+		return
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		cwlog.DoLog(true, "recordStats: %v", err)
+	}
+}
+
+// Read recorded totals from disk, skipping damaged lines
+func loadStats() error {
+	StatsLock.Lock()
+	defer StatsLock.Unlock()
+
+	body, err := os.ReadFile(statsFile)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	statsPoints = statsPoints[:0]
+	bad := 0
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		point := StatsPoint{}
+		if err := json.Unmarshal(scanner.Bytes(), &point); err != nil {
+			bad++
+			continue
+		}
+		statsPoints = append(statsPoints, point)
+	}
+	if bad > 0 {
+		cwlog.DoLog(true, "loadStats: skipped %v damaged lines", bad)
+	}
+	sort.SliceStable(statsPoints, func(i, j int) bool { return statsPoints[i].At.Before(statsPoints[j].At) })
+	return scanner.Err()
+}
+
+// Thin out old totals with the history retention policy, and rewrite the file
+func compactStats(now time.Time) (int, error) {
+	StatsLock.Lock()
+	defer StatsLock.Unlock()
+
+	times := make([]time.Time, len(statsPoints))
+	for i, point := range statsPoints {
+		times[i] = point.At
+	}
+	keep := retainedHistory(historyRetention, times, now)
+
+	kept := make([]StatsPoint, 0, len(statsPoints))
+	for i, point := range statsPoints {
+		if keep[i] {
+			kept = append(kept, point)
+		}
+	}
+	removed := len(statsPoints) - len(kept)
+	if removed == 0 {
+		return 0, nil
+	}
+
+	outbuf := new(bytes.Buffer)
+	enc := json.NewEncoder(outbuf)
+	for _, point := range kept {
+		if err := enc.Encode(point); err != nil {
+			return 0, err
+		}
+	}
+	tempPath := statsFile + ".tmp"
+	if err := os.WriteFile(tempPath, outbuf.Bytes(), 0644); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tempPath, statsFile); err != nil {
+		return 0, err
+	}
+
+	statsPoints = kept
+	return removed, nil
+}
+
+// Recorded totals from since onward, zero means everything
+func statsSince(since time.Time) []StatsPoint {
+	StatsLock.RLock()
+	defer StatsLock.RUnlock()
+
+	start := sort.Search(len(statsPoints), func(i int) bool { return !statsPoints[i].At.Before(since) })
+	return append([]StatsPoint(nil), statsPoints[start:]...)
+}
+
+// Average points into at most limit buckets of equal time
+func downsampleStats(points []StatsPoint, limit int) []StatsPoint {
+	if len(points) <= limit {
+		return points
+	}
+
+	from, to := points[0].At, points[len(points)-1].At
+	span := to.Sub(from) + 1
+	result := make([]StatsPoint, 0, limit)
+	var sum StatsPoint
+	count, bucket := 0, -1
+
+	flush := func() {
+		if count == 0 {
+			return
+		}
+		avg := StatsPoint{
+			At:       sum.At,
+			Players:  sum.Players / count,
+			Servers:  sum.Servers / count,
+			Modded:   sum.Modded / count,
+			Versions: map[string]int{},
+		}
+		for ver, num := range sum.Versions {
+			avg.Versions[ver] = num / count
+		}
+		result = append(result, avg)
+	}
+
+	for _, point := range points {
+		b := int(int64(point.At.Sub(from)) * int64(limit) / int64(span))
+		if b != bucket {
+			flush()
+			sum = StatsPoint{At: point.At, Versions: map[string]int{}}
+			count, bucket = 0, b
+		}
+		sum.Players += point.Players
+		sum.Servers += point.Servers
+		sum.Modded += point.Modded
+		for ver, num := range point.Versions {
+			sum.Versions[ver] += num
+		}
+		count++
+	}
+	flush()
+	return result
+}
+
+// Build the charts for the stats page
+func buildStatsPage(rangeName string, now time.Time) *StatsPage {
+	page := &StatsPage{Enabled: len(historyRetention) > 0}
+	selected := statsRanges[0]
+	for _, sr := range statsRanges {
+		page.Ranges = append(page.Ranges, sr.Name)
+		if sr.Name == rangeName {
+			selected = sr
+		}
+	}
+	page.Range = selected.Name
+
+	since := time.Time{}
+	if selected.Span > 0 {
+		since = now.Add(-selected.Span)
+	}
+	points := statsSince(since)
+	page.Points = len(points)
+	if len(points) == 0 {
+		return page
+	}
+	page.Latest = points[len(points)-1]
+	points = downsampleStats(points, MaxChartPoints)
+
+	times := make([]time.Time, len(points))
+	players := make([]float64, len(points))
+	servers := make([]float64, len(points))
+	modded := make([]float64, len(points))
+	vanilla := make([]float64, len(points))
+	for i, point := range points {
+		times[i] = point.At
+		players[i] = float64(point.Players)
+		servers[i] = float64(point.Servers)
+		if point.Servers > 0 {
+			modded[i] = float64(point.Modded) * 100 / float64(point.Servers)
+			vanilla[i] = 100 - modded[i]
+		}
+	}
+
+	page.Charts = []StatsChart{
+		{Title: "Players online", SVG: lineChartSVG(times, []chartSeries{{Name: "Players", Values: players}}, "")},
+		{Title: "Servers", SVG: lineChartSVG(times, []chartSeries{{Name: "Servers", Values: servers}}, "")},
+		{Title: "Modded vs vanilla", SVG: lineChartSVG(times, []chartSeries{
+			{Name: "Modded", Values: modded},
+			{Name: "Vanilla", Values: vanilla},
+		}, "%")},
+		{Title: "Version adoption", SVG: lineChartSVG(times, versionSeries(points), "%")},
+	}
+	return page
+}
+
+// Share of servers per version, the most common ones plus other
+func versionSeries(points []StatsPoint) []chartSeries {
+	totals := map[string]int{}
+	for _, point := range points {
+		for ver, num := range point.Versions {
+			totals[ver] += num
+		}
+	}
+	versions := make([]string, 0, len(totals))
+	for ver := range totals {
+		versions = append(versions, ver)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		if totals[versions[i]] != totals[versions[j]] {
+			return totals[versions[i]] > totals[versions[j]]
+		}
+		return versions[i] < versions[j]
+	})
+
+	shown := versions
+	if len(shown) > MaxChartVersions-1 {
+		shown = shown[:MaxChartVersions-1]
+	}
+	series := make([]chartSeries, len(shown), len(shown)+1)
+	other := chartSeries{Name: "other", Values: make([]float64, len(points))}
+	for s, ver := range shown {
+		series[s] = chartSeries{Name: ver, Values: make([]float64, len(points))}
+	}
+
+	hasOther := false
+	for i, point := range points {
+		if point.Servers == 0 {
+			continue
+		}
+		rest := 100.0
+		for s, ver := range shown {
+			series[s].Values[i] = float64(point.Versions[ver]) * 100 / float64(point.Servers)
+			rest -= series[s].Values[i]
+		}
+		if rest > 0.5 {
+			other.Values[i] = rest
+			hasOther = true
+		}
+	}
+	if hasOther {
+		series = append(series, other)
+	}
+	return series
+}
+
+// Time-series line chart as inline SVG, with axis labels and a legend
+func lineChartSVG(times []time.Time, series []chartSeries, unit string) template.HTML {
+	if len(times) == 0 {
+		return ""
+	}
+
+	peak := 0.0
+	for _, s := range series {
+		for _, v := range s.Values {
+			peak = math.Max(peak, v)
+		}
+	}
+	if unit == "%" {
+		peak = 100
+	}
+	peak = niceCeil(peak)
+
+	from, to := times[0], times[len(times)-1]
+	span := float64(to.Sub(from))
+	plotW := float64(chartWidth - chartLeft - chartRight)
+	plotH := float64(chartHeight - chartTop - chartBottom)
+
+	var buf strings.Builder
+	fmt.Fprintf(&buf, `<svg class="chart" viewBox="0 0 %d %d" role="img" preserveAspectRatio="none">`, chartWidth, chartHeight)
+
+	//Grid and y labels
+	for i := 0; i <= 4; i++ {
+		y := chartTop + plotH - plotH*float64(i)/4
+		fmt.Fprintf(&buf, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" stroke="#444" stroke-width="1"/>`,
+			chartLeft, y, chartWidth-chartRight, y)
+		fmt.Fprintf(&buf, `<text x="%d" y="%.1f" fill="#a0a0a0" font-size="11" text-anchor="end">%s%s</text>`,
+			chartLeft-5, y+4, formatChartValue(peak*float64(i)/4), unit)
+	}
+
+	//X labels, start and end
+	layout := "Jan 2 15:04"
+	fmt.Fprintf(&buf, `<text x="%d" y="%d" fill="#a0a0a0" font-size="11">%s</text>`,
+		chartLeft, chartHeight-5, from.UTC().Format(layout))
+	fmt.Fprintf(&buf, `<text x="%d" y="%d" fill="#a0a0a0" font-size="11" text-anchor="end">%s UTC</text>`,
+		chartWidth-chartRight, chartHeight-5, to.UTC().Format(layout))
+
+	for s, line := range series {
+		color := chartColors[s%len(chartColors)]
+		points := make([]string, len(line.Values))
+		for i, v := range line.Values {
+			x := float64(chartLeft)
+			if span > 0 {
+				x += plotW * float64(times[i].Sub(from)) / span
+			}
+			y := float64(chartTop) + plotH
+			if peak > 0 {
+				y -= plotH * v / peak
+			}
+			points[i] = fmt.Sprintf("%.1f,%.1f", x, y)
+		}
+		fmt.Fprintf(&buf, `<polyline fill="none" stroke="%s" stroke-width="2" points="%s"/>`, color, strings.Join(points, " "))
+
+		//Legend, top left
+		fmt.Fprintf(&buf, `<rect x="%d" y="%d" width="10" height="10" fill="%s"/>`, chartLeft+10+s*90, chartTop+2, color)
+		fmt.Fprintf(&buf, `<text x="%d" y="%d" fill="#e0e0e0" font-size="11">%s</text>`,
+			chartLeft+24+s*90, chartTop+11, html.EscapeString(line.Name))
+	}
+
+	buf.WriteString("</svg>")
+	return template.HTML(buf.String())
+}
+
+// Round up to 1, 2 or 5 times a power of ten
+func niceCeil(value float64) float64 {
+	if value <= 0 {
+		return 1
+	}
+	scale := math.Pow(10, math.Floor(math.Log10(value)))
+	for _, step := range []float64{1, 2, 5, 10} {
+		if value <= step*scale {
+			return step * scale
+		}
+	}
+	return 10 * scale
+}
+
+func formatChartValue(value float64) string {
+	if value == math.Trunc(value) {
+		return fmt.Sprintf("%.0f", value)
+	}
+	return fmt.Sprintf("%.1f", value)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnapshotStatsCountsModdedAndVersions(t *testing.T) {
+	setupDurafmt()
+
+	servers := seedServers(4)
+	servers[0].Mod_count = 3
+	servers[1].Application_version.Game_version = "1.1.110"
+	snap := newSnapshot(processServerList(servers), time.Now())
+
+	point := snapshotStats(snap)
+	if point.Servers != 4 || point.Players != 4 {
+		t.Fatalf("expected 4 servers and players, got %+v", point)
+	}
+	if point.Modded != 1 {
+		t.Fatalf("expected 1 modded server, got %d", point.Modded)
+	}
+	if point.Versions["2.0"] != 3 || point.Versions["1.1"] != 1 {
+		t.Fatalf("unexpected version counts: %v", point.Versions)
+	}
+}
+
+func TestDownsampleStatsAverages(t *testing.T) {
+	base := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	var points []StatsPoint
+	for i := 0; i < 100; i++ {
+		points = append(points, StatsPoint{At: base.Add(time.Duration(i) * time.Minute), Players: i, Servers: 10})
+	}
+
+	result := downsampleStats(points, 10)
+	if len(result) != 10 {
+		t.Fatalf("expected 10 points, got %d", len(result))
+	}
+	if result[0].Players != 4 || result[0].Servers != 10 {
+		t.Fatalf("expected first bucket to average 0-9, got %+v", result[0])
+	}
+}
+
+func TestStatsRecordLoadAndCompact(t *testing.T) {
+	restore := configureHistoryTestState(t)
+	defer restore()
+
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	old := now.Add(-30*24*time.Hour - 30*time.Minute)
+	for _, when := range []time.Time{old, old.Add(5 * time.Minute), now} {
+		recordStats(newSnapshot(seedServers(MinValidCount+1), when))
+	}
+
+	statsPoints = nil
+	if err := loadStats(); err != nil {
+		t.Fatal(err)
+	}
+	if len(statsPoints) != 3 {
+		t.Fatalf("expected 3 loaded points, got %d", len(statsPoints))
+	}
+
+	removed, err := compactStats(now)
+	if err != nil || removed != 1 {
+		t.Fatalf("expected 1 point removed, got %d, %v", removed, err)
+	}
+	statsPoints = nil
+	if err := loadStats(); err != nil {
+		t.Fatal(err)
+	}
+	if len(statsPoints) != 2 {
+		t.Fatalf("expected 2 points after compaction, got %d", len(statsPoints))
+	}
+}
+
+func TestStatsPageRendersCharts(t *testing.T) {
+	parseTemplate()
+
+	restore := configureHistoryTestState(t)
+	defer restore()
+
+	now := time.Now().UTC()
+	for i := 3; i > 0; i-- {
+		recordStats(newSnapshot(seedServers(MinValidCount+i), now.Add(-time.Duration(i)*time.Hour)))
+	}
+
+	page := buildStatsPage("day", now)
+	if page.Points != 3 || len(page.Charts) != 4 {
+		t.Fatalf("expected 3 points and 4 charts, got %d and %d", page.Points, len(page.Charts))
+	}
+
+	rec := httptest.NewRecorder()
+	reqHandle(rec, httptest.NewRequest(http.MethodGet, "/stats?range=day", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d", rec.Code)
+	}
+	body := rec.Body.String()
+	if strings.Count(body, "<svg") != 4 || strings.Contains(body, "<script") {
+		t.Fatalf("expected 4 SVG charts and no script, got %q", body)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// First cache version that carries a checksum
+const ChecksumCacheVersion = 4
+
+// Write and fsync a file, the caller renames it into place
+func writeFileSynced(path string, body []byte) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(body)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+// Make a rename in dir durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// sha256 of a server list, ignoring whitespace
+func cacheChecksum(servers []byte) (string, error) {
+	compact := new(bytes.Buffer)
+	if err := json.Compact(compact, servers); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(compact.Bytes())
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// Check a cache file's checksum. Files from before checksums pass as they are.
+func verifyCacheChecksum(body []byte) error {
+	var header struct {
+		Version  int
+		Checksum string
+		Servers  json.RawMessage
+	}
+	if err := json.Unmarshal(body, &header); err != nil {
+		return fmt.Errorf("invalid cache JSON: %w", err)
+	}
+	if header.Version < ChecksumCacheVersion {
+		return nil
+	}
+	if header.Checksum == "" {
+		return fmt.Errorf("cache has no checksum")
+	}
+
+	sum, err := cacheChecksum(header.Servers)
+	if err != nil {
+		return fmt.Errorf("invalid server list: %w", err)
+	}
+	if !strings.EqualFold(sum, header.Checksum) {
+		return fmt.Errorf("checksum mismatch: file says %v, data is %v", header.Checksum, sum)
+	}
+	return nil
+}
+
+// Primary cache file, then backups newest first
+func cachePaths() []string {
+	paths := []string{CacheFile}
+	for i := 1; i <= CacheBackups; i++ {
+		paths = append(paths, cacheBackupPath(i))
+	}
+	return paths
+}
+
+func cacheBackupPath(num int) string {
+	return fmt.Sprintf("%v.%d", CacheFile, num)
+}
+
+// Shift backups down one, and keep the current cache as the newest,
+// if it's good. The oldest backup falls off the end.
+func rotateCacheBackups() {
+	if CacheBackups <= 0 {
+		return
+	}
+	body, err := os.ReadFile(CacheFile)
+	if err != nil || verifyCacheChecksum(body) != nil {
+		return
+	}
+
+	for i := CacheBackups; i > 1; i-- {
+		os.Rename(cacheBackupPath(i-1), cacheBackupPath(i))
+	}
+	os.Rename(CacheFile, cacheBackupPath(1))
+}
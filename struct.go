@@ -1,6 +1,7 @@
 package main
 
 import (
+	"html/template"
 	"time"
 )
 
@@ -13,12 +14,24 @@ type appVersionData struct {
 
 type CacheData struct {
 	Version int
-	Servers []ServerListItem
+	//sha256 of Servers, written to disk only
+	Checksum string `json:",omitempty"`
+	Servers  []ServerListItem
+
+	//Data came from a peer, not matchmaking
+	Mirrored bool `json:",omitempty"`
+	//When the servers were fetched, only sent to peers
+	FetchedAt time.Time `json:",omitzero"`
+
+	//Validators for conditional requests
+	ETag         string `json:",omitempty"`
+	LastModified string `json:",omitempty"`
 }
 
 type ServerListItem struct {
 	Application_version appVersionData
 	Description         string
+	Game_id             int
 	Game_time_elapsed   interface{}
 	Has_password        bool
 	Host_address        string
@@ -29,28 +42,129 @@ type ServerListItem struct {
 
 	//Local data
 	Local ServerMetaData
+
+	//Set if this entry couldn't be decoded
+	decodeErr error
+}
+
+// Server data from get-game-details
+type ServerDetailsData struct {
+	Application_version appVersionData
+	Description         string
+	Game_id             int
+	Game_time_elapsed   interface{}
+	Has_password        bool
+	Headless_server     bool
+	Host_address        string
+	Last_heartbeat      float64
+	Max_players         int
+	Mod_count           int
+	Mods                []modData
+	Name                string
+	Players             []string
+	Server_id           string
+	Tags                []string
+
+	//Local data
+	Local DetailMetaData
+}
+
+type modData struct {
+	Name    string
+	Version string
 }
 
-// Server state
+// Data for the server details template
+type ServerDetailPage struct {
+	GameID int
+	Server *ServerDetailsData
+	Error  string
+}
+
+type DetailMetaData struct {
+	NameHTML     template.HTML
+	DescHTML     template.HTML
+	ConnectURL   string
+	TimeStr      string
+	Players      int
+	HeartbeatStr string
+	FetchedAt    time.Time
+	FetchedStr   string
+}
+
+// Matchmaking server and credentials
+type UpstreamConfig struct {
+	URL, Token, Username *string
+}
+
+// An immutable server list, with totals
+type Snapshot struct {
+	Servers       []ServerListItem
+	VersionList   []VersionData
+	VersionGroups []VersionGroup
+	ServersCount  int
+	PlayerCount   int
+	FetchedAt     time.Time
+	//Words in Servers, for ranked search
+	Index *searchIndex
+
+	//Data came from a peer, not matchmaking
+	Mirrored   bool
+	MirrorPeer string
+
+	//Validators for conditional requests
+	ETag, LastModified string
+
+	//Servers that failed validation, and some of them
+	Quarantined       int
+	QuarantineSamples []QuarantinedServer
+}
+
+// Per-request page state, built from the current Snapshot
 type ServerStateData struct {
-	URL, Query, Token, Username *string
-	ServerList                  CacheData
-	LastRefresh                 time.Time
-	LastAttempt                 time.Time
+	Servers       []ServerListItem
+	VersionList   []VersionData
+	VersionGroups []VersionGroup
+	LastRefresh   time.Time
+	Mirrored      bool
+	MirrorPeer    string
+	Breaker       circuitBreaker
 	ServersCount,
 	PlayerCount,
+	Quarantined,
 	NumPages,
 	CurrentPage,
 	ItemsPerPage int
 
-	FTag, FName, FDesc, FPlayer    bool
-	SPlayers, SName, STime, SRTime bool
-	VanillaOnly, ModdedOnly        bool
-	HasPass, AnyPass               bool
-	HasPlay, NoPlay                bool
-	VersionList                    []VersionData
+	FTag, FName, FDesc, FPlayer, FText         bool
+	SPlayers, SName, STime, SRTime, SRelevance bool
+	VanillaOnly, ModdedOnly                    bool
+	HasPass, AnyPass                           bool
+	HasPlay, NoPlay                            bool
+	Refreshing                                 bool
+	//Serving from disk only, and how old that data is
+	Offline bool
+	DataAge string
 
-	FVersion, UserAgent, Searched string
+	FVersion, Searched string
+	//FVersion isn't in the dropdown, only servers on the newest patch of their minor
+	FVersionCustom, LatestOnly bool
+	//Typo tolerant name or player search, and the edits it allows
+	Fuzzy          bool
+	FuzzyTolerance int
+
+	//Numeric filters as typed, and parsed
+	MinPlayers, MaxPlayers, MinTime, MaxTime, MinMods, MaxMods string
+	playerRange, timeRange, modRange                           intRange
+
+	//Counts over the filtered servers, with links to narrow them
+	Facets []Facet
+
+	//Filter query from q, and why it didn't parse
+	Query, QueryError string
+	query             serverQuery
+	//Search index for Servers, before filtering
+	index *searchIndex
 }
 
 type VersionData struct {
@@ -63,12 +177,22 @@ type versionInt struct {
 }
 
 type ServerMetaData struct {
+	//Name as sent, tags and all, for marking matches in
+	RawName string
+	//Rich text, rendered to safe HTML
+	NameHTML template.HTML
+	DescHTML template.HTML
+	TagsHTML []template.HTML
+
 	ConnectURL string
 	TimeStr    string
 	Minutes    int
 	Modded     bool
 	Players    int
 	HasPlayers bool
+	//Search score and what a fuzzy search matched, set per request
+	Relevance int           `json:"-"`
+	MatchHTML template.HTML `json:"-"`
 
 	Icon     string
 	Homepage string
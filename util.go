@@ -3,7 +3,7 @@ package main
 import (
 	"fmt"
 	"html/template"
-	"regexp"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
@@ -16,16 +16,28 @@ import (
 func backgroundUpdateList() {
 	for {
 		time.Sleep(BGFetchInterval)
-		FetchLock.Lock()
-		fetchServerList()
-		FetchLock.Unlock()
+		triggerRefresh()
 	}
 }
 
 // Parse the template
 func parseTemplate() {
 	var err error
-	tmpl, err = template.ParseFiles("data/template.html")
+	tmpl, err = template.New("template.html").Funcs(template.FuncMap{
+		"sparkline": serverSparkline,
+	}).ParseFiles(filepath.Join(templateDir, "template.html"))
+	if err != nil {
+		panic(err)
+	}
+	dTmpl, err = template.ParseFiles(filepath.Join(templateDir, "server.html"))
+	if err != nil {
+		panic(err)
+	}
+	sTmpl, err = template.ParseFiles(filepath.Join(templateDir, "stats.html"))
+	if err != nil {
+		panic(err)
+	}
+	pTmpl, err = template.ParseFiles(filepath.Join(templateDir, "player.html"))
 	if err != nil {
 		panic(err)
 	}
@@ -75,6 +87,13 @@ func sortServers(promote bool, list []ServerListItem, sortBy int) []ServerListIt
 		sort.Slice(list, func(i, j int) bool {
 			return list[i].Local.Minutes > list[j].Local.Minutes
 		})
+	} else if sortBy == SORT_RELEVANCE {
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Local.Relevance == list[j].Local.Relevance {
+				return len(list[i].Players) > len(list[j].Players)
+			}
+			return list[i].Local.Relevance > list[j].Local.Relevance
+		})
 	} else {
 		sort.Slice(list, func(i, j int) bool {
 
@@ -149,35 +168,17 @@ func getMinutes(item ServerListItem) int {
 	return 0
 }
 
-// Factorio tag removal
-var (
-	remFactTag      *regexp.Regexp = regexp.MustCompile(`\[/[^][]+\]`)
-	remFactCloseTag *regexp.Regexp = regexp.MustCompile(`\[(.*?)=(.*?)\]`)
-)
-
-func RemoveFactorioTags(input string) string {
-	buf := input
-	buf = remFactCloseTag.ReplaceAllString(buf, "")
-	buf = remFactTag.ReplaceAllString(buf, "")
-
-	buf = strings.Replace(buf, "\n\r", "\n", -1)
-	buf = strings.Replace(buf, "\r", "\n", -1)
-	buf = strings.Replace(buf, "\n\n", "\n", -1)
-	buf = strings.Replace(buf, "\n", "  ", -1)
-
-	return buf
-}
-
 // Generate a quick-connect link
 func MakeSteamURL(host string) string {
 	buf := fmt.Sprintf("https://go-game.net/gosteam/427520.--mp-connect%%20%v", host)
 	return buf
 }
 
-func getVersions() {
+// Count servers for each game version
+func getVersions(servers []ServerListItem) []VersionData {
 
 	versionList := []VersionData{}
-	for _, server := range sParam.ServerList.Servers {
+	for _, server := range servers {
 		foundVersion := false
 		for v, vItem := range versionList {
 			if server.Application_version.Game_version == vItem.Version {
@@ -191,5 +192,5 @@ func getVersions() {
 		}
 	}
 
-	sParam.VersionList = sortVersions(versionList)
+	return sortVersions(versionList)
 }
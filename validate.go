@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"goFactServView/cwlog"
+	"net"
+	"regexp"
+	"strconv"
+	"unicode/utf8"
+)
+
+const (
+	//Longest server name we'll accept, in bytes
+	MaxNameLength = 1024
+	//Fetches in a row showing the same drop before we believe it
+	DropConfirmations = 3
+	//Quarantined servers kept for inspection
+	MaxQuarantineSamples = 50
+)
+
+var (
+	//Reject the whole list if more than this share of servers are bad
+	maxQuarantine = 0.2
+	//Hold back a list that shrank by more than this share
+	maxDrop = 0.5
+
+	//Fetches in a row that were held back, RefreshLock
+	heldBack int
+
+	errHeldBack = errors.New("server list held back")
+
+	gameVersionRegex = regexp.MustCompile(`^[0-9]+\.[0-9]+\.[0-9]+$`)
+)
+
+// A server entry that failed validation
+type QuarantinedServer struct {
+	Name         string
+	Host_address string
+	Reason       string
+}
+
+// Check a single raw server entry
+func validateServer(item ServerListItem) error {
+	if item.decodeErr != nil {
+		return item.decodeErr
+	}
+
+	host, port, err := net.SplitHostPort(item.Host_address)
+	if err != nil {
+		return fmt.Errorf("bad host address %q: %w", item.Host_address, err)
+	}
+	if host == "" {
+		return fmt.Errorf("bad host address %q: no host", item.Host_address)
+	}
+	if portNum, err := strconv.Atoi(port); err != nil || portNum < 1 || portNum > 65535 {
+		return fmt.Errorf("bad host address %q: invalid port", item.Host_address)
+	}
+
+	if !gameVersionRegex.MatchString(item.Application_version.Game_version) {
+		return fmt.Errorf("bad game version %q", item.Application_version.Game_version)
+	}
+
+	if !utf8.ValidString(item.Name) {
+		return errors.New("name is not valid UTF-8")
+	}
+	if len(item.Name) > MaxNameLength {
+		return fmt.Errorf("name is %d bytes, limit %d", len(item.Name), MaxNameLength)
+	}
+	return nil
+}
+
+// Split a raw list into valid servers, and quarantined ones
+func quarantineServers(list []ServerListItem) ([]ServerListItem, []QuarantinedServer) {
+	valid := make([]ServerListItem, 0, len(list))
+	var quarantined []QuarantinedServer
+
+	for _, item := range list {
+		if err := validateServer(item); err != nil {
+			quarantined = append(quarantined, QuarantinedServer{
+				Name:         item.Name,
+				Host_address: item.Host_address,
+				Reason:       err.Error(),
+			})
+			continue
+		}
+		valid = append(valid, item)
+	}
+	return valid, quarantined
+}
+
+// Too many bad entries means upstream is sending garbage
+func checkQuarantine(total int, quarantined []QuarantinedServer) error {
+	if len(quarantined) == 0 {
+		return nil
+	}
+	cwlog.DoLog(true, "Quarantined %v of %v servers, first: %v (%v)",
+		len(quarantined), total, quarantined[0].Reason, quarantined[0].Host_address)
+
+	if float64(len(quarantined)) > float64(total)*maxQuarantine {
+		return fmt.Errorf("%d of %d servers failed validation", len(quarantined), total)
+	}
+	return nil
+}
+
+// Hold back a list much smaller than the last one,
+// unless we've seen it DropConfirmations times in a row
+func checkListDrop(prevCount, newCount int) error {
+	if prevCount == 0 || float64(newCount) >= float64(prevCount)*(1-maxDrop) {
+		heldBack = 0
+		return nil
+	}
+
+	heldBack++
+	if heldBack >= DropConfirmations {
+		cwlog.DoLog(true, "Server count dropped from %v to %v, confirmed %v times, accepting.", prevCount, newCount, heldBack)
+		heldBack = 0
+		return nil
+	}
+	return fmt.Errorf("%w: server count dropped from %d to %d (%d/%d)",
+		errHeldBack, prevCount, newCount, heldBack, DropConfirmations)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateServer(t *testing.T) {
+	good := ServerListItem{
+		Name:                "ok",
+		Host_address:        "10.0.0.1:34197",
+		Application_version: appVersionData{Game_version: "2.0.28"},
+	}
+	if err := validateServer(good); err != nil {
+		t.Fatalf("expected valid server, got %v", err)
+	}
+
+	cases := map[string]func(*ServerListItem){
+		"no port":      func(item *ServerListItem) { item.Host_address = "10.0.0.1" },
+		"bad port":     func(item *ServerListItem) { item.Host_address = "10.0.0.1:99999" },
+		"no host":      func(item *ServerListItem) { item.Host_address = ":34197" },
+		"bad version":  func(item *ServerListItem) { item.Application_version.Game_version = "2.0" },
+		"bad utf8":     func(item *ServerListItem) { item.Name = "bad\xff" },
+		"long name":    func(item *ServerListItem) { item.Name = strings.Repeat("a", MaxNameLength+1) },
+		"decode error": func(item *ServerListItem) { item.decodeErr = errors.New("bad") },
+	}
+	for name, breakIt := range cases {
+		item := good
+		breakIt(&item)
+		if err := validateServer(item); err == nil {
+			t.Fatalf("%v: expected validation error", name)
+		}
+	}
+}
+
+func TestDecodeServerListFlagsMalformedEntry(t *testing.T) {
+	body := `[{"Name":"ok","Host_address":"1.2.3.4:1","Application_version":{"Game_version":"1.1.1"}},
+		{"Name":["not","a","string"],"Host_address":"1.2.3.4:2"}, "not a server",
+		{"Name":"after","Host_address":"1.2.3.4:3","Application_version":{"Game_version":"1.1.1"}}]`
+	list, err := decodeServerList(strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("expected malformed entries to be flagged, not fail the list: %v", err)
+	}
+	valid, quarantined := quarantineServers(list)
+	if len(valid) != 2 || len(quarantined) != 2 || valid[1].Name != "after" {
+		t.Fatalf("expected 2 valid and 2 quarantined, got %+v and %v", valid, quarantined)
+	}
+}
+
+func TestFetchServerListQuarantinesBadEntries(t *testing.T) {
+	setupDurafmt()
+
+	restore := configureFetchTestState(t)
+	defer restore()
+
+	servers := seedServers(MinValidCount + 10)
+	servers[0].Host_address = "nope"
+	servers[1].Application_version.Game_version = "x"
+	serverSource = &stubSource{list: servers}
+
+	if err := fetchServerList(); err != nil {
+		t.Fatalf("fetchServerList returned error: %v", err)
+	}
+	snap := getSnapshot()
+	if snap.ServersCount != MinValidCount+8 || snap.Quarantined != 2 || len(snap.QuarantineSamples) != 2 {
+		t.Fatalf("expected 2 quarantined, got count=%d quarantined=%d", snap.ServersCount, snap.Quarantined)
+	}
+
+	//Too many bad entries rejects the whole list
+	servers = seedServers(MinValidCount + 10)
+	for i := range servers[:len(servers)/2] {
+		servers[i].Host_address = ""
+	}
+	serverSource = &stubSource{list: servers}
+	markStale()
+	if err := fetchServerList(); err == nil || !strings.Contains(err.Error(), "failed validation") {
+		t.Fatalf("expected validation error, got %v", err)
+	}
+}
+
+func TestFetchServerListHoldsBackLargeDrop(t *testing.T) {
+	setupDurafmt()
+
+	restore := configureFetchTestState(t)
+	defer restore()
+
+	original := newSnapshot(seedServers(200), time.Time{})
+	publishSnapshot(original)
+	serverSource = &stubSource{list: seedServers(MinValidCount + 1)}
+
+	for i := 1; i < DropConfirmations; i++ {
+		lastAttempt.Store(0)
+		err := fetchServerList()
+		if !errors.Is(err, errHeldBack) {
+			t.Fatalf("fetch %d: expected held back, got %v", i, err)
+		}
+		if getSnapshot() != original {
+			t.Fatalf("fetch %d: expected original snapshot to remain", i)
+		}
+	}
+	if breaker.Failures != 0 {
+		t.Fatalf("held back lists shouldn't count as upstream failures, got %d", breaker.Failures)
+	}
+
+	lastAttempt.Store(0)
+	if err := fetchServerList(); err != nil {
+		t.Fatalf("expected confirmed drop to be accepted, got %v", err)
+	}
+	if got := getSnapshot().ServersCount; got != MinValidCount+1 {
+		t.Fatalf("expected %d servers after confirmation, got %d", MinValidCount+1, got)
+	}
+}
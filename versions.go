@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// One comparison, like >=2.0.20 or 2.0.*
+type versionConstraint struct {
+	Op  string
+	Ver versionInt
+	//Parts given, the rest are wildcards
+	Parts int
+}
+
+// Constraints in each alternative are ANDed, alternatives (split by ||) are ORed
+type versionRange [][]versionConstraint
+
+// Parse a version expression, like ">=2.0.20", "2.0.*", "1.1.x" or ">=1.1 <2.0 || 2.0.28"
+func parseVersionRange(expr string) (versionRange, error) {
+	var vr versionRange
+	for _, alt := range strings.Split(expr, "||") {
+		var constraints []versionConstraint
+		fields := strings.FieldsFunc(alt, func(r rune) bool { return r == ' ' || r == ',' })
+		for i := 0; i < len(fields); i++ {
+			field := fields[i]
+			//">= 2.0.20", operator on its own
+			if strings.Trim(field, "<>=") == "" && i+1 < len(fields) {
+				i++
+				field += fields[i]
+			}
+			constraint, err := parseVersionConstraint(field)
+			if err != nil {
+				return nil, err
+			}
+			constraints = append(constraints, constraint)
+		}
+		if len(constraints) == 0 {
+			return nil, fmt.Errorf("empty version range")
+		}
+		vr = append(vr, constraints)
+	}
+	return vr, nil
+}
+
+func parseVersionConstraint(field string) (versionConstraint, error) {
+	constraint := versionConstraint{Op: "="}
+	for _, op := range []string{">=", "<=", "==", ">", "<", "="} {
+		if strings.HasPrefix(field, op) {
+			field = field[len(op):]
+			if op != "==" {
+				constraint.Op = op
+			}
+			break
+		}
+	}
+
+	parts := strings.Split(field, ".")
+	if field == "" || len(parts) > 3 {
+		return constraint, fmt.Errorf("%q isn't a version like 2.0.28", field)
+	}
+	nums := [3]int{}
+	for i, part := range parts {
+		if part == "*" || part == "x" || part == "X" {
+			if i != len(parts)-1 {
+				return constraint, fmt.Errorf("%q: only the last parts can be wildcards", field)
+			}
+			break
+		}
+		num, err := strconv.Atoi(part)
+		if err != nil || num < 0 {
+			return constraint, fmt.Errorf("%q: %q isn't a number or wildcard", field, part)
+		}
+		nums[i] = num
+		constraint.Parts = i + 1
+	}
+	constraint.Ver = versionInt{a: nums[0], b: nums[1], c: nums[2]}
+	if constraint.Parts == 0 && constraint.Op != "=" {
+		return constraint, fmt.Errorf("%q: can't compare with a wildcard", field)
+	}
+	return constraint, nil
+}
+
+// Compare the first parts of two versions
+func compareVersions(x, y versionInt, parts int) int {
+	for i, pair := range [][2]int{{x.a, y.a}, {x.b, y.b}, {x.c, y.c}} {
+		if i >= parts {
+			break
+		}
+		if pair[0] != pair[1] {
+			if pair[0] < pair[1] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func (constraint versionConstraint) match(ver versionInt) bool {
+	cmp := compareVersions(ver, constraint.Ver, constraint.Parts)
+	switch constraint.Op {
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	}
+	return cmp == 0
+}
+
+func (vr versionRange) Match(version string) bool {
+	ver := parseVersion(version)
+	for _, constraints := range vr {
+		matched := true
+		for _, constraint := range constraints {
+			if !constraint.match(ver) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Versions of one major.minor, newest first
+type VersionGroup struct {
+	Minor    string
+	Count    int
+	Versions []VersionData
+}
+
+// Nest versions under their major.minor, newest first
+func groupVersions(list []VersionData) []VersionGroup {
+	sorted := append([]VersionData{}, list...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return compareVersions(parseVersion(sorted[i].Version), parseVersion(sorted[j].Version), 3) > 0
+	})
+
+	var groups []VersionGroup
+	for _, ver := range sorted {
+		minor := minorVersion(ver.Version)
+		if len(groups) == 0 || groups[len(groups)-1].Minor != minor {
+			groups = append(groups, VersionGroup{Minor: minor})
+		}
+		group := &groups[len(groups)-1]
+		group.Count += ver.Count
+		group.Versions = append(group.Versions, ver)
+	}
+	return groups
+}
+
+// The newest patch of each major.minor
+func latestPatches(groups []VersionGroup) map[string]bool {
+	latest := map[string]bool{}
+	for _, group := range groups {
+		if len(group.Versions) > 0 {
+			latest[group.Versions[0].Version] = true
+		}
+	}
+	return latest
+}
+
+// Is version one of the dropdown's choices
+func versionInDropdown(groups []VersionGroup, version string) bool {
+	for _, group := range groups {
+		if version == group.Minor+".*" {
+			return true
+		}
+		for _, ver := range group.Versions {
+			if ver.Version == version {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVersionRangeMatch(t *testing.T) {
+	cases := map[string]map[string]bool{
+		">=2.0.20":          {"2.0.20": true, "2.0.28": true, "2.0.9": false, "1.1.110": false, "2.1.0": true},
+		"2.0.*":             {"2.0.0": true, "2.0.28": true, "2.1.0": false},
+		"1.1.x":             {"1.1.110": true, "1.0.0": false},
+		"2.0":               {"2.0.5": true, "2.10.5": false},
+		"2.0.28":            {"2.0.28": true, "2.0.2": false},
+		"< 2.0":             {"1.1.110": true, "2.0.0": false},
+		">1.1":              {"1.1.110": false, "2.0.0": true},
+		">=1.1, <2.0.10":    {"1.1.0": true, "2.0.9": true, "2.0.10": false, "1.0.9": false},
+		"1.1.* || >=2.0.28": {"1.1.7": true, "2.0.27": false, "2.0.28": true},
+		"*":                 {"1.0.0": true, "2.0.28": true},
+	}
+	for expr, versions := range cases {
+		vr, err := parseVersionRange(expr)
+		if err != nil {
+			t.Fatalf("parseVersionRange(%q) returned error: %v", expr, err)
+		}
+		for version, want := range versions {
+			if got := vr.Match(version); got != want {
+				t.Fatalf("%q matching %v: expected %v, got %v", expr, version, want, got)
+			}
+		}
+	}
+
+	for _, expr := range []string{"", "abc", "2.*.1", "1.2.3.4", ">=*", "2.0 ||"} {
+		if _, err := parseVersionRange(expr); err == nil {
+			t.Fatalf("expected error for %q", expr)
+		}
+	}
+}
+
+func versionTestServers() []ServerListItem {
+	var items []ServerListItem
+	for i, version := range []string{"2.0.28", "2.0.28", "2.0.20", "1.1.110", "1.1.109", "1.1.110"} {
+		items = append(items, ServerListItem{Name: version, Host_address: "127.0.0.1:" + string(rune('1'+i)),
+			Application_version: appVersionData{Game_version: version}})
+	}
+	return processServerList(items)
+}
+
+func TestGroupVersions(t *testing.T) {
+	groups := groupVersions(getVersions(versionTestServers()))
+	if len(groups) != 2 || groups[0].Minor != "2.0" || groups[1].Minor != "1.1" {
+		t.Fatalf("expected 2.0 then 1.1, got %+v", groups)
+	}
+	if groups[0].Count != 3 || groups[0].Versions[0].Version != "2.0.28" || groups[0].Versions[1].Version != "2.0.20" {
+		t.Fatalf("expected newest patch first with totals, got %+v", groups[0])
+	}
+
+	latest := latestPatches(groups)
+	if len(latest) != 2 || !latest["2.0.28"] || !latest["1.1.110"] {
+		t.Fatalf("unexpected latest patches %v", latest)
+	}
+	if !versionInDropdown(groups, "1.1.*") || !versionInDropdown(groups, "2.0.20") || versionInDropdown(groups, ">=2.0") {
+		t.Fatal("unexpected dropdown choices")
+	}
+}
+
+func TestFilterServersVersions(t *testing.T) {
+	snap := newSnapshot(versionTestServers(), time.Now())
+	count := func(params *ServerStateData) int {
+		params.Servers, params.VersionGroups = snap.Servers, snap.VersionGroups
+		filterServers(params)
+		return params.ServersCount
+	}
+
+	if got := count(&ServerStateData{FVersion: ">=2.0.20"}); got != 3 {
+		t.Fatalf("expected 3 servers from 2.0.20 up, got %d", got)
+	}
+	if got := count(&ServerStateData{LatestOnly: true}); got != 4 {
+		t.Fatalf("expected 4 servers on the latest patch, got %d", got)
+	}
+	if got := count(&ServerStateData{FVersion: "1.1.x", LatestOnly: true}); got != 2 {
+		t.Fatalf("expected 2 servers on 1.1.110, got %d", got)
+	}
+	query, err := parseQuery("version<2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := count(&ServerStateData{query: query}); got != 3 {
+		t.Fatalf("expected 3 servers before 2.0, got %d", got)
+	}
+}
+
+func TestReqHandleVersionDropdown(t *testing.T) {
+	setupDurafmt()
+	parseTemplate()
+	restore := configureFetchTestState(t)
+	defer restore()
+	publishSnapshot(newSnapshot(versionTestServers(), time.Now()))
+
+	rec := httptest.NewRecorder()
+	reqHandle(rec, httptest.NewRequest(http.MethodGet, "/?version=%3E%3D2.0.20", nil))
+	body := rec.Body.String()
+	for _, want := range []string{`<optgroup label="2.0">`, `<option value="1.1.*" >1.1.* (3)</option>`,
+		`<option value="&gt;=2.0.20" selected>&gt;=2.0.20</option>`} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected %q in the page", want)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	reqHandle(rec, httptest.NewRequest(http.MethodGet, "/?version=2.x.1", nil))
+	if !strings.Contains(rec.Body.String(), "Query error in version:") {
+		t.Fatal("expected a bad range to be reported")
+	}
+}